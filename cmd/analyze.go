@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(analyzeCmd)
+	analyzeCmd.Flags().StringVarP(&field, "dimension", "d", "excl_wt", "Dimension to view/sort (wt, excl_wt, cpu, excl_cpu, memory, excl_memory, io, excl_io, num_alloc, num_free, alloc_amt, or any other event of callgrind files like Ir, excl_Ir)")
+	analyzeCmd.Flags().Float32VarP(&minPercent, "min", "m", 1, "Display items having minimum percentage (default 1% for inclusive, and 10% for exclusive dimensions) of --dimension, with respect to max value")
+	analyzeCmd.Flags().StringVarP(&outFile, "out-file", "o", "", "If provided, the path to store the resulting profile (e.g. after averaging)")
+	analyzeCmd.Flags().StringVarP(&function, "function", "", "", "If provided, one table for parents, and one for children of this function will be displayed")
+	analyzeCmd.Flags().StringVarP(&linesFunction, "lines", "", "", "If provided, the exclusive --dimension of this function will be displayed per line of its source")
+	analyzeCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	analyzeCmd.Flags().StringVarP(&includePattern, "include", "", "", includeUsage)
+	analyzeCmd.Flags().StringVarP(&excludePattern, "exclude", "", "", excludeUsage)
+	analyzeCmd.Flags().StringVarP(&focusPattern, "focus", "", "", focusUsage)
+	analyzeCmd.Flags().StringVarP(&ignorePattern, "ignore", "", "", ignoreUsage)
+	analyzeCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+	analyzeCmd.Flags().StringVarP(&output, "output", "", "table", outputUsage)
+	analyzeCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	analyzeCmd.Flags().StringVarP(&groupBy, "group-by", "", "", groupByUsage)
+}
+
+var (
+	field          string
+	minPercent     float32
+	outFile        string
+	function       string
+	inputFormat    string
+	parts          string
+	linesFunction  string
+	aggregate      string
+	groupBy        string
+	includePattern string
+	excludePattern string
+	focusPattern   string
+	ignorePattern  string
+)
+
+var analyzeCmd = &cobra.Command{
+	Use:     "analyze filepaths...",
+	Aliases: []string{"analyze-xhprof", "analyze-callgrind"},
+	Short:   "Parse profiles of any supported format into a sorted tabular output.",
+	Long:    `Parse profiles of any supported format into a sorted tabular output.`,
+	Args:    cobra.MinimumNArgs(1),
+	RunE:    analyze,
+}
+
+func analyze(cmd *cobra.Command, args []string) error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
+
+	maps, err := loadPairCallMaps(args, inputFormat, parts)
+	if err != nil {
+		return err
+	}
+
+	if maps, err = groupMaps(maps, groupBy); err != nil {
+		return err
+	}
+
+	avgMap, profile, err := aggregateMaps(maps, aggregate)
+	if err != nil {
+		return err
+	}
+
+	if run := describeRun(avgMap); run != "" {
+		printInfo("Profile of %s\n", run)
+	}
+
+	if len(maps) > 1 && aggregate != "mean" {
+		printInfo("Showing the %s of %d profiles\n", aggregate, len(maps))
+	}
+
+	if outFile != "" {
+		printInfo("Writing profile to %s\n", outFile)
+		f := xhprof.NewFile(outFile, "xhprof")
+		err := f.WritePairCallMap(avgMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if linesFunction != "" {
+		fieldInfo, ok := getFieldInfo(field, profile)
+		if !ok {
+			return fmt.Errorf("Provided dimension (%s) is not valid", field)
+		}
+
+		return renderSourceLines(avgMap, linesFunction, fieldInfo)
+	}
+
+	// Change default to 10 for exclusive fields, only when user
+	// hasn't manually provided 1%
+	if strings.HasPrefix(field, "excl_") && !cmd.Flags().Changed("min") {
+		minPercent = float32(10)
+	}
+	minPercent = minPercent / 100.0
+
+	if function == "" {
+		fieldInfo, ok := getFieldInfo(field, profile)
+		if !ok {
+			printInfo("Provided field (%s) is not valid, defaulting to excl_wt\n", field)
+			field = "excl_wt"
+			fieldInfo = fieldsMap[field]
+		}
+
+		profile.SortBy(fieldInfo.Name)
+		minValue := minPercent * profile.Calls[0].GetFloat32Field(fieldInfo.Name)
+		profile = profile.SelectGreater(fieldInfo.Name, minValue)
+		err := renderProfile(profile, field, fieldInfo, minValue)
+		if err != nil {
+			return err
+		}
+	} else {
+		family := avgMap.ComputeNearestFamily(function)
+		parentsProfile := family.Parents.Flatten()
+		childrenProfile := family.Children.Flatten()
+
+		field = "wt"
+		fieldInfo := fieldsMap[field]
+		minPercent = 0.1
+
+		functionCall := profile.GetCall(function)
+		if functionCall == nil {
+			return errors.New("Profile doesn't contain function")
+		}
+		minValue := minPercent * functionCall.GetFloat32Field(fieldInfo.Name)
+		profile.SortBy(fieldInfo.Name)
+		profile = profile.SelectGreater(fieldInfo.Name, minValue)
+
+		err := renderFamily(function, parentsProfile, childrenProfile, field, fieldInfo, minValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	if output == "table" {
+		fmt.Printf("Looking for a Web UI and SQL Profiling Support? Try our SaaS: https://tideways.io\n")
+	}
+
+	return nil
+}
+
+// jsonFamily is the JSON output of analyze for the parents and children of a
+// function.
+type jsonFamily struct {
+	Function  string      `json:"function"`
+	Dimension string      `json:"dimension"`
+	Label     string      `json:"label"`
+	Threshold float32     `json:"threshold"`
+	Parents   []*jsonCall `json:"parents"`
+	Children  []*jsonCall `json:"children"`
+}
+
+// renderFamily displays the parents and children of function in two tables,
+// or in a single one with a Relation column for CSV and TSV.
+func renderFamily(function string, parents, children *xhprof.Profile, field string, fieldInfo FieldInfo, minValue float32) error {
+	if output == "json" {
+		return renderJSON(&jsonFamily{
+			Function:  function,
+			Dimension: field,
+			Label:     fieldInfo.Label,
+			Threshold: minValue,
+			Parents:   newJSONCalls(parents.Calls),
+			Children:  newJSONCalls(children.Calls),
+		})
+	}
+
+	if isMachineOutput() {
+		withLocation := hasLocation(parents, children)
+		headers, parentRows := getProfileTable(parents, field, fieldInfo, minValue, withLocation)
+		_, childRows := getProfileTable(children, field, fieldInfo, minValue, withLocation)
+
+		rows := make([][]string, 0, len(parentRows)+len(childRows))
+		for _, row := range parentRows {
+			rows = append(rows, append([]string{"parent"}, row...))
+		}
+		for _, row := range childRows {
+			rows = append(rows, append([]string{"child"}, row...))
+		}
+
+		return renderTable(append([]string{"Relation"}, headers...), rows)
+	}
+
+	fmt.Printf("Parents of %s:\n", function)
+	err := renderProfile(parents, field, fieldInfo, minValue)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Children of %s:\n", function)
+
+	return renderProfile(children, field, fieldInfo, minValue)
+}
+
+// jsonSourceLine is the JSON output of analyze for a line of a function.
+type jsonSourceLine struct {
+	Line    int             `json:"line"`
+	Value   float32         `json:"value"`
+	Percent float32         `json:"percent"`
+	Calls   []*jsonCallSite `json:"calls"`
+}
+
+type jsonCallSite struct {
+	Function string `json:"function"`
+	Count    int    `json:"count"`
+}
+
+// jsonSourceLines is the JSON output of analyze for the lines of a function.
+type jsonSourceLines struct {
+	Function string            `json:"function"`
+	File     string            `json:"file"`
+	Line     int               `json:"line"`
+	Label    string            `json:"label"`
+	Lines    []*jsonSourceLine `json:"lines"`
+}
+
+// renderSourceLines displays the exclusive costs of function per line, along
+// with the functions called from each line.
+func renderSourceLines(m *xhprof.PairCallMap, function string, fieldInfo FieldInfo) error {
+	src, ok := m.Sources[function]
+	if !ok || (len(src.Lines) == 0 && len(src.CallSites) == 0) {
+		return fmt.Errorf("Profile doesn't contain lines of function %s", function)
+	}
+
+	// Lines only have exclusive costs, which are stored like the inclusive
+	// costs of a PairCall.
+	lineField := strings.TrimPrefix(fieldInfo.Name, "Exclusive")
+	if lineField == "IoTime" {
+		return fmt.Errorf("Provided dimension (%s) is not available per line", field)
+	}
+
+	var total float32
+	for _, costs := range src.Lines {
+		total += costs.GetFloat32Field(lineField)
+	}
+
+	label := strings.Replace(fieldInfo.Label, "Inclusive", "Exclusive", 1)
+	res := &jsonSourceLines{Function: function, File: src.File, Line: src.Line, Label: label}
+	for _, line := range src.SortedLines() {
+		l := &jsonSourceLine{Line: line, Calls: make([]*jsonCallSite, 0, 1)}
+		if costs, ok := src.Lines[line]; ok {
+			l.Value = costs.GetFloat32Field(lineField)
+		}
+
+		if total != 0 {
+			l.Percent = 100 * l.Value / total
+		}
+
+		for _, c := range src.GetCallSites(line) {
+			l.Calls = append(l.Calls, &jsonCallSite{Function: c.Function, Count: c.Count})
+		}
+
+		res.Lines = append(res.Lines, l)
+	}
+
+	if output == "json" {
+		return renderJSON(res)
+	}
+
+	percentFormat := "%2.2f %%"
+	if isMachineOutput() {
+		percentFormat = "%.2f"
+	}
+
+	rows := make([][]string, 0, len(res.Lines))
+	for _, l := range res.Lines {
+		calls := make([]string, 0, len(l.Calls))
+		for _, c := range l.Calls {
+			calls = append(calls, fmt.Sprintf("%s (%dx)", c.Function, c.Count))
+		}
+
+		callsCol := strings.Join(calls, ", ")
+		if !isMachineOutput() {
+			callsCol = fmt.Sprintf("%.90s", callsCol)
+		}
+
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", l.Line),
+			formatValue(l.Value, fieldInfo.Unit),
+			fmt.Sprintf(percentFormat, l.Percent),
+			callsCol,
+		})
+	}
+
+	printInfo("Showing %s of %s (%s) by line\n", label, function, formatLocation(src.File, src.Line))
+
+	return renderTable([]string{"Line", formatHeader("Excl. "+fieldInfo.Header, fieldInfo.Unit), "Percent", "Calls"}, rows)
+}
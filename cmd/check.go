@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVarP(&budgetFile, "budget", "b", "", "The YAML file with the performance budget")
+	checkCmd.Flags().StringSliceVarP(&baselinePaths, "baseline", "", nil, "Files, directories or quoted glob patterns of the baseline profiles for rules limiting an increase (default the baseline of the budget)")
+	checkCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	checkCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+	checkCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	checkCmd.Flags().StringVarP(&output, "output", "", "table", outputUsage)
+	checkCmd.MarkFlagRequired("budget")
+}
+
+// checkFailedStatus is the exit status of check when a rule of the budget is
+// violated, other errors exit with status 1.
+const checkFailedStatus = 2
+
+var (
+	budgetFile string
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check filepaths...",
+	Short: "Check profiles against the rules of a performance budget.",
+	Long: `Check profiles against the rules of a performance budget.
+
+The budget is a YAML file with a list of rules like "main() wt <= 120ms",
+"mysqli_query count <= 30", "Foo::bar excl_memory <= 2MB" or, compared to the
+baseline profiles, "main() wt increase <= 10%". Multiple profiles are combined
+with --aggregate. The command exits with status 2 if any rule is violated, so
+that it can fail builds in CI.`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE:          check,
+}
+
+// budgetResult is the outcome of a rule of the budget, with values in
+// microseconds and bytes.
+type budgetResult struct {
+	Rule      string   `json:"rule"`
+	Function  string   `json:"function"`
+	Dimension string   `json:"dimension"`
+	Value     float32  `json:"value"`
+	Baseline  *float32 `json:"baseline,omitempty"`
+	Called    bool     `json:"called"`
+	Passed    bool     `json:"passed"`
+
+	fieldInfo FieldInfo
+}
+
+func check(cmd *cobra.Command, args []string) error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
+
+	budget, err := loadBudget(budgetFile)
+	if err != nil {
+		return err
+	}
+
+	profile, err := loadCheckProfile(args)
+	if err != nil {
+		return err
+	}
+
+	if len(baselinePaths) == 0 {
+		baselinePaths = budget.Baseline
+	}
+
+	var baseline *xhprof.Profile
+	if len(baselinePaths) > 0 {
+		paths, err := expandPaths(baselinePaths)
+		if err != nil {
+			return err
+		}
+
+		if baseline, err = loadCheckProfile(paths); err != nil {
+			return err
+		}
+	}
+
+	results := make([]*budgetResult, 0, len(budget.Rules))
+	failed := 0
+	for _, rule := range budget.Rules {
+		r, err := checkRule(rule, profile, baseline)
+		if err != nil {
+			return err
+		}
+
+		if !r.Passed {
+			failed++
+		}
+
+		results = append(results, r)
+	}
+
+	if err = renderBudgetResults(results); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return NewCommandError(checkFailedStatus, fmt.Sprintf("%d of %d rules of the performance budget failed", failed, len(results)))
+	}
+
+	printInfo("All %d rules of the performance budget passed\n", len(results))
+
+	return nil
+}
+
+// loadBudget parses the budget file, with the baseline patterns made relative
+// to the directory of the budget file.
+func loadBudget(path string) (*xhprof.Budget, error) {
+	rd, err := xhprof.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	budget, err := xhprof.ParseBudget(rd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for i, pattern := range budget.Baseline {
+		if !filepath.IsAbs(pattern) {
+			budget.Baseline[i] = filepath.Join(filepath.Dir(path), pattern)
+		}
+	}
+
+	return budget, nil
+}
+
+// loadCheckProfile combines the profiles of paths with --aggregate.
+func loadCheckProfile(paths []string) (*xhprof.Profile, error) {
+	maps, err := loadPairCallMaps(paths, inputFormat, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	_, profile, err := aggregateMaps(maps, aggregate)
+
+	return profile, err
+}
+
+// checkRule checks the rule against the profile, and against the baseline
+// for increases.
+func checkRule(rule *xhprof.BudgetRule, profile, baseline *xhprof.Profile) (*budgetResult, error) {
+	fieldInfo, ok := FieldInfo{Name: "Count", Label: "Number of Calls", Header: "Calls", Unit: plain}, true
+	if rule.Dimension != "count" {
+		fieldInfo, ok = getFieldInfo(rule.Dimension, profile)
+	}
+	if !ok {
+		return nil, fmt.Errorf("Invalid dimension %s of budget rule %q", rule.Dimension, rule.Rule)
+	}
+
+	switch rule.Unit {
+	case "":
+		rule.Limit *= fieldInfo.Unit.Divisor
+	case xhprof.BudgetTime:
+		if fieldInfo.Unit != ms {
+			return nil, fmt.Errorf("Invalid budget rule %q, %s is not a time", rule.Rule, rule.Dimension)
+		}
+	case xhprof.BudgetMemory:
+		if fieldInfo.Unit != kb {
+			return nil, fmt.Errorf("Invalid budget rule %q, %s is not an amount of memory", rule.Rule, rule.Dimension)
+		}
+	}
+
+	if rule.Increase && baseline == nil {
+		return nil, fmt.Errorf("Budget rule %q needs baseline profiles, use --baseline", rule.Rule)
+	}
+
+	r := &budgetResult{
+		Rule:      rule.Rule,
+		Function:  rule.Function,
+		Dimension: rule.Dimension,
+		fieldInfo: fieldInfo,
+	}
+
+	if call := profile.GetCall(rule.Function); call != nil {
+		r.Value = call.GetFloat32Field(fieldInfo.Name)
+		r.Called = true
+	}
+
+	var baselineValue float32
+	if rule.Increase {
+		if call := baseline.GetCall(rule.Function); call != nil {
+			baselineValue = call.GetFloat32Field(fieldInfo.Name)
+		}
+
+		r.Baseline = &baselineValue
+	}
+
+	r.Passed = rule.Check(r.Value, baselineValue)
+
+	return r, nil
+}
+
+func renderBudgetResults(results []*budgetResult) error {
+	if output == "json" {
+		return renderJSON(results)
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, r := range results {
+		status := "ok"
+		if !r.Passed {
+			status = "FAILED"
+		}
+
+		value := formatValue(r.Value, r.fieldInfo.Unit)
+		if !r.Called && !isMachineOutput() {
+			value += " (not called)"
+		}
+
+		baseline, change := "", ""
+		if r.Baseline != nil {
+			baseline = formatValue(*r.Baseline, r.fieldInfo.Unit)
+			change = formatSigned(float64(r.Value-*r.Baseline), r.fieldInfo.Unit)
+			if *r.Baseline != 0 {
+				change += fmt.Sprintf(" (%+.1f%%)", 100*(r.Value-*r.Baseline) / *r.Baseline)
+			}
+		}
+
+		rows = append(rows, []string{r.Rule, value, baseline, change, status})
+	}
+
+	return renderTable([]string{"Rule", "Value", "Baseline", "Change", "Status"}, rows)
+}
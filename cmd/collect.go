@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(collectCmd)
+	collectCmd.Flags().StringVarP(&collectDir, "dir", "", ".", "The directory to store the profiles in")
+	collectCmd.Flags().StringVarP(&collectListen, "listen", "l", "127.0.0.1:8081", "The address to listen on")
+	collectCmd.Flags().Int64VarP(&maxSize, "max-size", "", 64, "The maximum size of a profile in MB, compressed and decompressed")
+	collectCmd.Flags().BoolVarP(&collectGzip, "gzip", "", false, "If present, the profiles are stored compressed with gzip")
+}
+
+var (
+	collectDir    string
+	collectListen string
+	maxSize       int64
+	collectGzip   bool
+)
+
+var collectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "Start an HTTP server that stores the profiles sent to it by PHP applications.",
+	Long: `Start an HTTP server that stores the profiles sent to it by PHP applications.
+
+Profiles are sent with a POST request containing the JSON of
+tideways_xhprof_disable(), compressed with gzip or not, and the request they
+were recorded for in the headers X-Profile-Url, X-Profile-Method,
+X-Profile-Host and X-Profile-Timestamp (Unix time or RFC 3339). They are stored
+as XHGui runs in DIR/HOST/METHOD-PATH/DATE/TIME-ID.json, which all other
+commands can read along with the request data.`,
+	Args: cobra.NoArgs,
+	RunE: collect,
+}
+
+// collectSlugPattern matches the characters replaced in the directory names
+// of collected profiles.
+var collectSlugPattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// collectedProfile is the request a profile sent to collect was recorded for.
+type collectedProfile struct {
+	URL    string
+	Method string
+	Host   string
+	Time   time.Time
+}
+
+func collect(cmd *cobra.Command, args []string) error {
+	if err := os.MkdirAll(collectDir, 0755); err != nil {
+		return err
+	}
+
+	fmt.Printf("Collecting profiles into %s on http://%s/\n", collectDir, collectListen)
+
+	return http.ListenAndServe(collectListen, http.HandlerFunc(collectProfile))
+}
+
+func collectProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Profiles must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, err := newCollectedProfile(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Decompress detects gzip from the data itself, so Content-Encoding
+	// does not have to be set. The limit applies to the decompressed data,
+	// too, so that a small gzip bomb can't exhaust the memory.
+	limit := maxSize * 1024 * 1024
+	body, err := readCollectLimit(w, r.Body, limit)
+	if err != nil {
+		return
+	}
+
+	rd, err := xhprof.Decompress(bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := readCollectLimit(w, rd, limit)
+	if err != nil {
+		return
+	}
+
+	m, err := xhprof.ParseXhprof(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Invalid profile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := m.M["main()"]; !ok {
+		http.Error(w, "Invalid profile: Call map has no main()", http.StatusBadRequest)
+		return
+	}
+
+	m.Meta = p.meta()
+
+	rel, err := p.path()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	path := filepath.Join(collectDir, rel)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = xhprof.NewFile(path, "xhgui").WritePairCallMap(m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("Stored profile of %s %s%s to %s\n", p.Method, p.Host, p.URL, path)
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, filepath.ToSlash(rel))
+}
+
+// readCollectLimit reads rd up to limit bytes. If it fails or there is more,
+// the error is written to w.
+func readCollectLimit(w http.ResponseWriter, rd io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(rd, limit+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		err = fmt.Errorf("Profile is larger than %d MB", maxSize)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func newCollectedProfile(h http.Header) (*collectedProfile, error) {
+	p := &collectedProfile{
+		URL:    h.Get("X-Profile-Url"),
+		Method: strings.ToUpper(h.Get("X-Profile-Method")),
+		Host:   h.Get("X-Profile-Host"),
+		Time:   time.Now(),
+	}
+
+	if ts := h.Get("X-Profile-Timestamp"); ts != "" {
+		if sec, err := strconv.ParseFloat(ts, 64); err == nil {
+			p.Time = time.Unix(0, int64(sec*float64(time.Second)))
+		} else if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			p.Time = t
+		} else {
+			return nil, fmt.Errorf("Invalid X-Profile-Timestamp (%s), use Unix time or RFC 3339", ts)
+		}
+	}
+
+	return p, nil
+}
+
+// meta returns the request in the keys of XHGui.
+func (p *collectedProfile) meta() map[string]string {
+	meta := map[string]string{
+		"request_ts":   p.Time.UTC().Format(time.RFC3339),
+		"request_date": p.Time.UTC().Format("2006-01-02"),
+	}
+
+	if p.URL != "" {
+		meta["url"] = p.URL
+		meta["simple_url"] = p.simpleURL()
+	}
+	if p.Method != "" {
+		meta["SERVER.REQUEST_METHOD"] = p.Method
+	}
+	if p.Host != "" {
+		meta["SERVER.HTTP_HOST"] = p.Host
+	}
+
+	return meta
+}
+
+// simpleURL returns the path of the URL, without query string.
+func (p *collectedProfile) simpleURL() string {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return p.URL
+	}
+
+	return u.Path
+}
+
+// path returns the path to store the profile at, relative to the directory of
+// all profiles, with a random suffix so that concurrent requests do not
+// overwrite each other.
+func (p *collectedProfile) path() (string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.New("Could not generate a file name: " + err.Error())
+	}
+
+	ext := ".json"
+	if collectGzip {
+		ext += ".gz"
+	}
+
+	t := p.Time.UTC()
+
+	return filepath.Join(
+		collectSlug(p.Host, "unknown-host"),
+		collectSlug(p.Method, "ANY")+"-"+collectSlug(p.simpleURL(), "index"),
+		t.Format("2006-01-02"),
+		t.Format("150405")+"-"+hex.EncodeToString(id)+ext,
+	), nil
+}
+
+// collectSlug turns s into a directory name, or returns fallback if nothing of
+// it is left.
+func collectSlug(s, fallback string) string {
+	s = strings.Trim(collectSlugPattern.ReplaceAllString(s, "_"), "_.-")
+	if s == "" {
+		return fallback
+	}
+
+	if len(s) > 100 {
+		s = s[:100]
+	}
+
+	return s
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const collectTestProfile = `{"main()": {"ct": 1, "wt": 1000}, "main()==>foo": {"ct": 2, "wt": 400}}`
+
+// postProfile sends body to collectProfile with the headers of a request and
+// returns the response.
+func postProfile(method string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", bytes.NewReader(body))
+	r.Header.Set("X-Profile-Url", "/blog/post?id=1")
+	r.Header.Set("X-Profile-Method", "post")
+	r.Header.Set("X-Profile-Host", "example.com")
+	r.Header.Set("X-Profile-Timestamp", "1500000000")
+
+	w := httptest.NewRecorder()
+	collectProfile(w, r)
+
+	return w
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.Nil(t, err)
+	require.Nil(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestCollectProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolkit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(d string, s int64, g bool) { collectDir, maxSize, collectGzip = d, s, g }(collectDir, maxSize, collectGzip)
+	collectDir, maxSize, collectGzip = dir, 1, false
+
+	w := postProfile(http.MethodGet, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+
+	for _, body := range [][]byte{[]byte(collectTestProfile), gzipData(t, []byte(collectTestProfile))} {
+		w = postProfile(http.MethodPost, body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		rel := strings.TrimSpace(w.Body.String())
+		assert.Regexp(t, `^example\.com/POST-blog_post/2017-07-14/024000-[0-9a-f]{8}\.json$`, rel)
+
+		m, err := xhprof.NewFile(filepath.Join(dir, filepath.FromSlash(rel)), "xhgui").GetPairCallMap()
+		require.Nil(t, err)
+		assert.Equal(t, 2, m.M["main()==>foo"].Count)
+		assert.Equal(t, "/blog/post?id=1", m.Meta["url"])
+	}
+
+	// Both the sent and the decompressed data are limited.
+	large := []byte(`{"main()": {"ct": 1, "wt": 1000}}` + strings.Repeat(" ", 1024*1024))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postProfile(http.MethodPost, large).Code)
+
+	compressed := gzipData(t, large)
+	require.True(t, len(compressed) < 1024*1024)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postProfile(http.MethodPost, compressed).Code)
+
+	assert.Equal(t, http.StatusBadRequest, postProfile(http.MethodPost, []byte(`{"main()": `)).Code)
+	assert.Equal(t, http.StatusBadRequest, postProfile(http.MethodPost, []byte(`{"foo==>bar": {"ct": 1, "wt": 10}}`)).Code)
+}
+
+func TestCollectSlug(t *testing.T) {
+	assert.Equal(t, "blog_post", collectSlug("/blog/post", "index"))
+	assert.Equal(t, "index", collectSlug("/../..", "index"))
+	assert.Equal(t, "index", collectSlug("", "index"))
+	assert.Equal(t, strings.Repeat("a", 100), collectSlug(strings.Repeat("a", 150), "index"))
+}
+
+func TestNewCollectedProfile(t *testing.T) {
+	for ts, expected := range map[string]time.Time{
+		"1500000000.5":              time.Unix(1500000000, 500000000),
+		"2017-07-14T02:40:00+02:00": time.Unix(1500000000-2*3600, 0),
+	} {
+		p, err := newCollectedProfile(http.Header{"X-Profile-Timestamp": {ts}})
+		require.Nil(t, err, ts)
+		assert.True(t, expected.Equal(p.Time), ts)
+	}
+
+	_, err := newCollectedProfile(http.Header{"X-Profile-Timestamp": {"yesterday"}})
+	assert.NotNil(t, err)
+
+	// Without timestamp, the profile was recorded just now.
+	p, err := newCollectedProfile(http.Header{"X-Profile-Method": {"get"}})
+	require.Nil(t, err)
+	assert.Equal(t, "GET", p.Method)
+	assert.WithinDuration(t, time.Now(), p.Time, time.Minute)
+}
@@ -0,0 +1,427 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().IntVarP(&limit, "limit", "n", 10, "Number of rows to display")
+	compareCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	compareCmd.Flags().StringVarP(&includePattern, "include", "", "", includeUsage)
+	compareCmd.Flags().StringVarP(&excludePattern, "exclude", "", "", excludeUsage)
+	compareCmd.Flags().StringVarP(&focusPattern, "focus", "", "", focusUsage)
+	compareCmd.Flags().StringVarP(&ignorePattern, "ignore", "", "", ignoreUsage)
+	compareCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+	compareCmd.Flags().StringVarP(&output, "output", "", "table", outputUsage)
+	compareCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	compareCmd.Flags().StringVarP(&groupBy, "group-by", "", "", groupByUsage)
+	compareCmd.Flags().StringSliceVarP(&baselinePaths, "baseline", "", nil, "Files, directories or quoted glob patterns of the profiles before the change, for a statistical comparison with --candidate")
+	compareCmd.Flags().StringSliceVarP(&candidatePaths, "candidate", "", nil, "Files, directories or quoted glob patterns of the profiles after the change, for a statistical comparison with --baseline")
+	compareCmd.Flags().StringSliceVarP(&compareDimensions, "dimension", "d", nil, "Dimensions of the statistical comparison (default all dimensions the profiles have data for)")
+	compareCmd.Flags().StringVarP(&significanceTest, "test", "", "welch", "Significance test of the statistical comparison (welch, mann-whitney)")
+	compareCmd.Flags().Float64VarP(&alpha, "alpha", "", 0.05, "Significance level of the statistical comparison")
+}
+
+var (
+	limit             int
+	baselinePaths     []string
+	candidatePaths    []string
+	compareDimensions []string
+	significanceTest  string
+	alpha             float64
+)
+
+var compareCmd = &cobra.Command{
+	Use:     "compare filepaths...",
+	Aliases: []string{"compare-xhprof", "compare-callgrind"},
+	Short:   "Compare two profiles of any supported format and display them in a sorted table.",
+	Long: `Compare two profiles of any supported format and display them in a sorted table.
+
+With --baseline and --candidate, two sets of profiles are compared instead,
+e.g. of many requests before and after a deploy. The mean, standard deviation
+and confidence interval of each function are computed for every dimension, and
+changes that are statistically significant are marked, so that a single noisy
+request can not fake a regression.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(baselinePaths) == 0 && len(candidatePaths) == 0 {
+			return cobra.ExactArgs(2)(cmd, args)
+		}
+
+		if len(args) > 0 {
+			return fmt.Errorf("Unexpected arguments %s, quote the glob patterns of --baseline and --candidate", strings.Join(args, " "))
+		}
+
+		return nil
+	},
+	RunE: compare,
+}
+
+func compare(cmd *cobra.Command, args []string) error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
+
+	if len(baselinePaths) > 0 || len(candidatePaths) > 0 {
+		return compareSets()
+	}
+
+	profiles := make([]*xhprof.Profile, 0, len(args))
+	for _, arg := range args {
+		maps, err := loadPairCallMaps([]string{arg}, inputFormat, parts)
+		if err != nil {
+			return err
+		}
+
+		if maps, err = groupMaps(maps, groupBy); err != nil {
+			return err
+		}
+
+		avgMap, profile, err := aggregateMaps(maps, aggregate)
+		if err != nil {
+			return err
+		}
+
+		if run := describeRun(avgMap); run != "" {
+			printInfo("Profile %d: %s\n", len(profiles)+1, run)
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	diff := profiles[0].Subtract(profiles[1])
+
+	err := renderProfileDiff(diff, limit)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// compareSets compares the profiles of --baseline and --candidate in every
+// dimension of --dimension.
+func compareSets() error {
+	var test xhprof.SignificanceTest
+	var testName string
+	switch significanceTest {
+	case "welch":
+		test, testName = xhprof.WelchTTest, "Welch's t-test"
+	case "mann-whitney":
+		test, testName = xhprof.MannWhitneyU, "Mann-Whitney U test"
+	default:
+		return fmt.Errorf("Provided significance test (%s) is not valid, use welch or mann-whitney", significanceTest)
+	}
+
+	baseline, err := loadProfileSet(baselinePaths, "--baseline")
+	if err != nil {
+		return err
+	}
+
+	candidate, err := loadProfileSet(candidatePaths, "--candidate")
+	if err != nil {
+		return err
+	}
+
+	var fieldInfos []FieldInfo
+	if len(compareDimensions) == 0 {
+		fieldInfos = getDimensions(xhprof.AvgProfiles(baseline))
+	}
+
+	for _, dimension := range compareDimensions {
+		fieldInfo, ok := getFieldInfo(dimension, baseline[0])
+		if !ok {
+			return fmt.Errorf("Provided dimension (%s) is not valid", dimension)
+		}
+
+		fieldInfos = append(fieldInfos, fieldInfo)
+	}
+
+	res := &jsonComparison{
+		Test:       significanceTest,
+		Alpha:      alpha,
+		Baseline:   len(baseline),
+		Candidate:  len(candidate),
+		Limit:      limit,
+		Dimensions: make([]*jsonDimensionComparison, 0, len(fieldInfos)),
+	}
+
+	for _, fieldInfo := range fieldInfos {
+		calls := xhprof.CompareProfiles(baseline, candidate, fieldInfo.Name, test)
+		sortComparisons(calls)
+		if len(calls) > limit {
+			calls = calls[:limit]
+		}
+
+		res.Dimensions = append(res.Dimensions, newJSONDimensionComparison(fieldInfo, calls))
+	}
+
+	if output == "json" {
+		return renderJSON(res)
+	}
+
+	printInfo(
+		"Comparing %d baseline with %d candidate profiles, showing means ± 95%% confidence interval\n"+
+			"Changes marked with * are significant (p < %g, %s)\n\n",
+		len(baseline), len(candidate), alpha, testName,
+	)
+
+	if isMachineOutput() {
+		return renderComparisonRecords(res, fieldInfos)
+	}
+
+	for i, d := range res.Dimensions {
+		printInfo("%s:\n", d.Label)
+		if err := renderComparisonTable(d, fieldInfos[i].Unit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadProfileSet loads the profiles of paths, which are files, directories
+// or glob patterns. Each run of XHGui exports and each part of callgrind files
+// with --parts avg is a profile of its own.
+func loadProfileSet(patterns []string, flag string) ([]*xhprof.Profile, error) {
+	paths, err := expandPaths(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	maps, err := loadPairCallMaps(paths, inputFormat, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	if maps, err = groupMaps(maps, groupBy); err != nil {
+		return nil, err
+	}
+
+	if len(maps) < 2 {
+		return nil, fmt.Errorf("The statistical comparison needs at least two profiles for %s, found %d", flag, len(maps))
+	}
+
+	profiles := make([]*xhprof.Profile, 0, len(maps))
+	for _, m := range maps {
+		profiles = append(profiles, m.Flatten())
+	}
+
+	return profiles, nil
+}
+
+// expandPaths returns the files matching the glob patterns, and the files of
+// directories, leaving out hidden files.
+func expandPaths(patterns []string) ([]string, error) {
+	paths := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			files, err := ioutil.ReadDir(pattern)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range files {
+				if f.Mode().IsRegular() && !strings.HasPrefix(f.Name(), ".") {
+					paths = append(paths, filepath.Join(pattern, f.Name()))
+				}
+			}
+
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %s: %s", pattern, err)
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No profiles found for %s", pattern)
+		}
+
+		paths = append(paths, matches...)
+	}
+
+	return paths, nil
+}
+
+// sortComparisons sorts significant changes first, each by the absolute
+// change of the means.
+func sortComparisons(calls []*xhprof.CallComparison) {
+	sort.SliceStable(calls, func(i, j int) bool {
+		si, sj := calls[i].Significant(alpha), calls[j].Significant(alpha)
+		if si != sj {
+			return si
+		}
+
+		return math.Abs(calls[i].Change()) > math.Abs(calls[j].Change())
+	})
+}
+
+// jsonComparison is the JSON output of compare with --baseline and
+// --candidate, with all values in microseconds and bytes.
+type jsonComparison struct {
+	Test       string                     `json:"test"`
+	Alpha      float64                    `json:"alpha"`
+	Baseline   int                        `json:"baseline"`
+	Candidate  int                        `json:"candidate"`
+	Limit      int                        `json:"limit"`
+	Dimensions []*jsonDimensionComparison `json:"dimensions"`
+}
+
+type jsonDimensionComparison struct {
+	Dimension string                `json:"dimension"`
+	Label     string                `json:"label"`
+	Calls     []*jsonCallComparison `json:"calls"`
+}
+
+type jsonCallComparison struct {
+	Name          string      `json:"name"`
+	Baseline      jsonSummary `json:"baseline"`
+	Candidate     jsonSummary `json:"candidate"`
+	Change        float64     `json:"change"`
+	ChangePercent float64     `json:"change_percent"`
+	PValue        float64     `json:"p_value"`
+	Significant   bool        `json:"significant"`
+}
+
+type jsonSummary struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+	CILow  float64 `json:"ci_low"`
+	CIHigh float64 `json:"ci_high"`
+}
+
+func newJSONDimensionComparison(fieldInfo FieldInfo, calls []*xhprof.CallComparison) *jsonDimensionComparison {
+	d := &jsonDimensionComparison{
+		Dimension: getDimensionName(fieldInfo),
+		Label:     fieldInfo.Label,
+		Calls:     make([]*jsonCallComparison, 0, len(calls)),
+	}
+
+	for _, c := range calls {
+		d.Calls = append(d.Calls, &jsonCallComparison{
+			Name:          c.Name,
+			Baseline:      newJSONSummary(c.Baseline),
+			Candidate:     newJSONSummary(c.Candidate),
+			Change:        c.Change(),
+			ChangePercent: c.RelativeChange(),
+			PValue:        c.PValue,
+			Significant:   c.Significant(alpha),
+		})
+	}
+
+	return d
+}
+
+func newJSONSummary(s xhprof.Summary) jsonSummary {
+	return jsonSummary{Mean: s.Mean, StdDev: s.StdDev, CILow: s.CILow, CIHigh: s.CIHigh}
+}
+
+// getDimensionName returns the name of the dimension of fieldInfo, as given
+// to --dimension.
+func getDimensionName(fieldInfo FieldInfo) string {
+	for name, info := range fieldsMap {
+		if info == fieldInfo {
+			return name
+		}
+	}
+
+	if strings.HasPrefix(fieldInfo.Name, "Exclusive") {
+		return "excl_" + strings.TrimPrefix(fieldInfo.Name, "ExclusiveCosts.")
+	}
+
+	return strings.TrimPrefix(fieldInfo.Name, "Costs.")
+}
+
+// renderComparisonTable displays the comparison of one dimension, with the
+// standard deviations and the change in percent of the baseline.
+func renderComparisonTable(d *jsonDimensionComparison, unit Unit) error {
+	headers := []string{"Function", "Baseline", "Std. Dev.", "Candidate", "Std. Dev.", "Change", "p-Value", ""}
+	rows := make([][]string, 0, len(d.Calls))
+	for _, c := range d.Calls {
+		change := formatSigned(c.Change, unit)
+		if c.Baseline.Mean == 0 {
+			change += " (new)"
+		} else {
+			change += fmt.Sprintf(" (%+.1f%%)", c.ChangePercent)
+		}
+
+		marker := ""
+		if c.Significant {
+			marker = "*"
+		}
+
+		rows = append(rows, []string{
+			fmt.Sprintf("%.60s", c.Name),
+			formatMeanCI(c.Baseline, unit),
+			formatValue(float32(c.Baseline.StdDev), unit),
+			formatMeanCI(c.Candidate, unit),
+			formatValue(float32(c.Candidate.StdDev), unit),
+			change,
+			fmt.Sprintf("%.4f", c.PValue),
+			marker,
+		})
+	}
+
+	return renderTable(headers, rows)
+}
+
+// renderComparisonRecords writes the comparisons of all dimensions as a
+// single CSV or TSV table, with a column for each value and the unit of the
+// values of each dimension.
+func renderComparisonRecords(res *jsonComparison, fieldInfos []FieldInfo) error {
+	headers := []string{
+		"Dimension", "Unit", "Function",
+		"Baseline Mean", "Baseline Std. Dev.", "Baseline CI Low", "Baseline CI High",
+		"Candidate Mean", "Candidate Std. Dev.", "Candidate CI Low", "Candidate CI High",
+		"Change", "Change (%)", "p-Value", "Significant",
+	}
+
+	rows := make([][]string, 0)
+	for i, d := range res.Dimensions {
+		unit := fieldInfos[i].Unit
+		for _, c := range d.Calls {
+			row := []string{d.Dimension, unit.Name, c.Name}
+			for _, s := range []jsonSummary{c.Baseline, c.Candidate} {
+				for _, v := range []float64{s.Mean, s.StdDev, s.CILow, s.CIHigh} {
+					row = append(row, formatNumber(float32(v), unit))
+				}
+			}
+
+			rows = append(rows, append(row,
+				formatNumber(float32(c.Change), unit),
+				fmt.Sprintf("%.2f", c.ChangePercent),
+				fmt.Sprintf("%.6f", c.PValue),
+				fmt.Sprintf("%t", c.Significant),
+			))
+		}
+	}
+
+	return renderTable(headers, rows)
+}
+
+// formatMeanCI formats the mean with the half width of its 95% confidence
+// interval.
+func formatMeanCI(s jsonSummary, unit Unit) string {
+	return fmt.Sprintf("%s ± %s", formatValue(float32(s.Mean), unit), formatNumber(float32(s.CIHigh-s.Mean), unit))
+}
+
+// formatSigned formats a change of a value with its sign.
+func formatSigned(value float64, unit Unit) string {
+	if value > 0 {
+		return "+" + formatValue(float32(value), unit)
+	}
+
+	return formatValue(float32(value), unit)
+}
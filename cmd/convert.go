@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(convertCmd)
+	convertCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	convertCmd.Flags().StringVarP(&outputFormat, "to", "", "xhprof", "Format of the output file (xhprof, xhgui, callgrind, collapsed, pprof, speedscope, chrome)")
+	convertCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	convertCmd.Flags().StringSliceVarP(&convertDimensions, "dimensions", "", []string{"wt", "cpu", "memory"}, "Dimensions of the speedscope format (wt, cpu, memory, num_alloc, num_free, alloc_amt, or any event of callgrind files like Ir)")
+	convertCmd.Flags().StringVarP(&outFile, "out-file", "o", "", "The path to store the converted profile")
+}
+
+var (
+	outputFormat      string
+	convertDimensions []string
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert filepaths...",
+	Short: "Convert profiles into another format, combining them with --aggregate if multiple are given.",
+	Long:  `Convert profiles into another format, combining them with --aggregate if multiple are given.`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  convert,
+}
+
+func convert(cmd *cobra.Command, args []string) error {
+	if outFile == "" {
+		return errors.New("The path to store the converted profile must be provided with --out-file")
+	}
+
+	// Function traces keep the order of calls, which the chrome format can
+	// show on a timeline instead of stacking calls by their costs.
+	if outputFormat == "chrome" && len(args) == 1 {
+		in := xhprof.NewFile(args[0], inputFormat)
+		format, err := in.DetectFormat()
+		if err != nil {
+			return err
+		}
+
+		if format == "xdebug-trace" {
+			trace, err := in.GetTrace()
+			if err != nil {
+				return err
+			}
+
+			if err = xhprof.NewFile(outFile, outputFormat).WriteTrace(trace); err != nil {
+				return err
+			}
+
+			fmt.Printf("Written %s timeline to %s\n", outputFormat, outFile)
+
+			return nil
+		}
+	}
+
+	maps, err := loadPairCallMaps(args, inputFormat, "sum")
+	if err != nil {
+		return err
+	}
+
+	avgMap, _, err := aggregateMaps(maps, aggregate)
+	if err != nil {
+		return err
+	}
+
+	f := xhprof.NewFile(outFile, outputFormat)
+	for _, dimension := range convertDimensions {
+		// Events of callgrind files are not in fieldsMap.
+		fieldInfo, err := getFlameGraphFieldInfo(dimension)
+		if err != nil {
+			fieldInfo = getEventFieldInfo(dimension, false)
+		}
+
+		f.Dimensions = append(f.Dimensions, fieldInfo.Name)
+	}
+
+	err = f.WritePairCallMap(avgMap)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Written %s profile to %s\n", outputFormat, outFile)
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(generateXhprofFlamegraphCmd)
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&flamegraphDimension, "dimension", "d", "wt", "Inclusive dimension used for the width of the frames (wt, cpu, memory, num_alloc, num_free, alloc_amt)")
+	generateXhprofFlamegraphCmd.Flags().Float32VarP(&threshold, "threshold", "t", 1, "Display items having greater ratio of wt (default 1%) with respect to main()")
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&includePattern, "include", "", "", includeUsage)
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&excludePattern, "exclude", "", "", excludeUsage)
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&focusPattern, "focus", "", "", focusUsage)
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&ignorePattern, "ignore", "", "", ignoreUsage)
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	generateXhprofFlamegraphCmd.Flags().StringVarP(&outFile, "out-file", "o", "", "The path to store the resulting SVG (default \"flamegraph.svg\")")
+}
+
+var (
+	flamegraphDimension string
+)
+
+var generateXhprofFlamegraphCmd = &cobra.Command{
+	Use:   "generate-xhprof-flamegraph filepaths...",
+	Short: "Parse profiles of any supported format into an interactive SVG flame graph.",
+	Long:  `Parse profiles of any supported format into an interactive SVG flame graph.`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  generateXhprofFlamegraph,
+}
+
+func generateXhprofFlamegraph(cmd *cobra.Command, args []string) error {
+	fieldInfo, err := getFlameGraphFieldInfo(flamegraphDimension)
+	if err != nil {
+		return err
+	}
+
+	maps, err := loadPairCallMaps(args, inputFormat, parts)
+	if err != nil {
+		return err
+	}
+
+	avgMap, _, err := aggregateMaps(maps, aggregate)
+	if err != nil {
+		return err
+	}
+
+	threshold /= 100
+	title := fmt.Sprintf("Flame Graph by %s", fieldInfo.Label)
+	svg, err := xhprof.GenerateFlameGraph(avgMap, fieldInfo.Name, title, fieldInfo.Unit.Name, fieldInfo.Unit.Divisor, threshold)
+	if err != nil {
+		return err
+	}
+
+	if len(outFile) == 0 {
+		outFile = "flamegraph.svg"
+	}
+
+	err = writeOutFile(outFile, []byte(svg))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Written flame graph to SVG file: %s\n", outFile)
+
+	return nil
+}
+
+// getFlameGraphFieldInfo returns the FieldInfo of an inclusive dimension that
+// can be used for the width of flame graph frames.
+func getFlameGraphFieldInfo(dimension string) (FieldInfo, error) {
+	fieldInfo, ok := fieldsMap[dimension]
+	if !ok || strings.HasPrefix(dimension, "excl_") || dimension == "io" {
+		return FieldInfo{}, fmt.Errorf("Provided dimension (%s) is not valid for flame graphs", dimension)
+	}
+
+	return fieldInfo, nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(graphCmd)
+	graphCmd.Flags().Float32VarP(&threshold, "threshold", "t", 1, "Display items having greater ratio of excl_wt (default 1%) with respect to main()")
+	graphCmd.Flags().StringVarP(&function, "function", "f", "", "If provided, the graph will be generated only for functions directly related to this one")
+	graphCmd.Flags().BoolVarP(&criticalPath, "critical-path", "", false, "If present, the critical path will be highlighted (default true with --diff)")
+	graphCmd.Flags().BoolVarP(&graphDiff, "diff", "", false, "If present, the graph will show the difference between two profiles")
+	graphCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	graphCmd.Flags().StringVarP(&includePattern, "include", "", "", includeUsage)
+	graphCmd.Flags().StringVarP(&excludePattern, "exclude", "", "", excludeUsage)
+	graphCmd.Flags().StringVarP(&focusPattern, "focus", "", "", focusUsage)
+	graphCmd.Flags().StringVarP(&ignorePattern, "ignore", "", "", ignoreUsage)
+	graphCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+	graphCmd.Flags().StringVarP(&outFile, "out-file", "o", "", "The path to store the resulting graph (default \"callgraph.dot\")")
+	graphCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+}
+
+var (
+	threshold    float32
+	criticalPath bool
+	graphDiff    bool
+)
+
+var graphCmd = &cobra.Command{
+	Use:     "graph filepaths...",
+	Aliases: []string{"generate-xhprof-graphviz", "generate-xhprof-diff-graphviz"},
+	Short:   "Parse profiles of any supported format into a dot script for graphviz.",
+	Long: `Parse profiles of any supported format into a dot script for graphviz.
+
+With --diff, or when called as generate-xhprof-diff-graphviz, the graph shows
+the difference between two profiles.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if isGraphDiff(cmd) {
+			return cobra.ExactArgs(2)(cmd, args)
+		}
+
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	RunE: graph,
+}
+
+func isGraphDiff(cmd *cobra.Command) bool {
+	return graphDiff || cmd.CalledAs() == "generate-xhprof-diff-graphviz"
+}
+
+func graph(cmd *cobra.Command, args []string) error {
+	var dot string
+	threshold /= 100
+	if isGraphDiff(cmd) {
+		loaded := make([]*xhprof.PairCallMap, 0, len(args))
+		for _, arg := range args {
+			maps, err := loadPairCallMaps([]string{arg}, inputFormat, parts)
+			if err != nil {
+				return err
+			}
+
+			m, _, err := aggregateMaps(maps, aggregate)
+			if err != nil {
+				return err
+			}
+
+			loaded = append(loaded, m)
+		}
+
+		// The path of the largest changes is highlighted in diffs, unless
+		// --critical-path=false is given.
+		if !cmd.Flags().Changed("critical-path") {
+			criticalPath = true
+		}
+
+		var err error
+		dot, err = xhprof.GenerateDiffDotScript(loaded[0], loaded[1], threshold, function, criticalPath)
+		if err != nil {
+			return err
+		}
+	} else {
+		maps, err := loadPairCallMaps(args, inputFormat, parts)
+		if err != nil {
+			return err
+		}
+
+		avgMap, _, err := aggregateMaps(maps, aggregate)
+		if err != nil {
+			return err
+		}
+
+		dot, err = xhprof.GenerateDotScript(avgMap, threshold, function, criticalPath, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(outFile) == 0 {
+		outFile = "callgraph.dot"
+	}
+
+	err := writeOutFile(outFile, []byte(dot))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Written callgraph to graphviz dotfile: %s\n", outFile)
+	fmt.Printf("Looking for interactive Web-based Callgraph? Try our SaaS: https://tideways.io\n")
+
+	return nil
+}
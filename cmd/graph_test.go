@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runGraph runs the graph command on the profiles from and to with args and
+// returns the dot script. The flags are reset to their defaults first, as
+// they are kept from the previous run otherwise.
+func runGraph(t *testing.T, dir string, args ...string) string {
+	graphCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	})
+
+	out := filepath.Join(dir, "callgraph.dot")
+	args = append([]string{"graph", "--diff", "-o", out}, args...)
+	args = append(args, filepath.Join(dir, "from.xhprof"), filepath.Join(dir, "to.xhprof"))
+
+	RootCmd.SetArgs(args)
+	require.Nil(t, RootCmd.Execute())
+
+	dot, err := ioutil.ReadFile(out)
+	require.Nil(t, err)
+
+	return string(dot)
+}
+
+func TestGraphDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolkit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "from.xhprof"), []byte(`{
+		"main()": {"ct": 1, "wt": 1000},
+		"main()==>foo": {"ct": 1, "wt": 600},
+		"main()==>baz": {"ct": 1, "wt": 300},
+		"foo==>bar": {"ct": 2, "wt": 400}
+	}`), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "to.xhprof"), []byte(`{
+		"main()": {"ct": 1, "wt": 1550},
+		"main()==>foo": {"ct": 1, "wt": 1100},
+		"main()==>baz": {"ct": 1, "wt": 350},
+		"foo==>bar": {"ct": 4, "wt": 800}
+	}`), 0644))
+
+	// The critical path is highlighted by default in diffs.
+	dot := runGraph(t, dir)
+	assert.Contains(t, dot, "baz")
+	assert.Contains(t, dot, "fillcolor=yellow")
+
+	dot = runGraph(t, dir, "--function", "bar")
+	assert.Contains(t, dot, "Calls: 4 - 2 = 2")
+	assert.NotContains(t, dot, "baz")
+	assert.Equal(t, 1, strings.Count(dot, "->"))
+
+	dot = runGraph(t, dir, "--critical-path=false")
+	assert.Contains(t, dot, "baz")
+	assert.NotContains(t, dot, "fillcolor=yellow")
+}
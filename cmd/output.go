@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// outputUsage is the help of the --output flag of all commands printing
+// tables.
+const outputUsage = "Format of the output (table, markdown, csv, tsv, json)"
+
+var (
+	output string
+)
+
+func validateOutput() error {
+	switch output {
+	case "table", "markdown", "csv", "tsv", "json":
+		return nil
+	}
+
+	return fmt.Errorf("Provided output format (%s) is not valid, use table, markdown, csv, tsv or json", output)
+}
+
+// isMachineOutput returns whether the output is meant to be read by scripts,
+// which is any format other than table and markdown.
+func isMachineOutput() bool {
+	return output != "table" && output != "markdown"
+}
+
+// printInfo prints text around the tables, which is left out of output meant
+// to be read by scripts.
+func printInfo(format string, a ...interface{}) {
+	if isMachineOutput() {
+		return
+	}
+
+	fmt.Printf(format, a...)
+}
+
+// renderTable writes the rows to stdout as ASCII or Markdown table, or as CSV
+// or TSV with the headers as first record.
+func renderTable(headers []string, rows [][]string) error {
+	switch output {
+	case "csv", "tsv":
+		w := csv.NewWriter(os.Stdout)
+		if output == "tsv" {
+			w.Comma = '\t'
+		}
+
+		w.Write(headers)
+		w.WriteAll(rows)
+
+		return w.Error()
+	case "markdown":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(headers)
+		table.SetAutoFormatHeaders(false)
+		table.SetAutoWrapText(false)
+		table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+		table.SetCenterSeparator("|")
+		for _, row := range rows {
+			escaped := make([]string, len(row))
+			for i, col := range row {
+				escaped[i] = strings.Replace(col, "|", "\\|", -1)
+			}
+
+			table.Append(escaped)
+		}
+		table.Render()
+
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(headers)
+	table.AppendBulk(rows)
+	table.Render()
+
+	return nil
+}
+
+// renderJSON writes v to stdout as indented JSON.
+func renderJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(v)
+}
+
+// formatValue formats a value of a dimension in its unit, which is left out
+// of output meant to be read by scripts, where it is part of the header.
+func formatValue(value float32, unit Unit) string {
+	if isMachineOutput() {
+		return formatNumber(value, unit)
+	}
+
+	return fmt.Sprintf("%2s %s", formatNumber(value, unit), unit.Name)
+}
+
+// formatNumber formats a value of a dimension in its unit, without the name
+// of the unit.
+func formatNumber(value float32, unit Unit) string {
+	if unit == plain {
+		return fmt.Sprintf("%.0f", value/unit.Divisor)
+	}
+
+	return fmt.Sprintf("%.2f", value/unit.Divisor)
+}
+
+// formatHeader returns the header of a column in unit, which is added to the
+// header for output meant to be read by scripts.
+func formatHeader(header string, unit Unit) string {
+	if !isMachineOutput() || unit.Name == "" {
+		return header
+	}
+
+	return fmt.Sprintf("%s (%s)", header, unit.Name)
+}
+
+// jsonCall holds all metrics of a call, in microseconds and bytes, with the
+// names of the dimensions as keys.
+type jsonCall struct {
+	Name            string             `json:"name"`
+	File            string             `json:"file,omitempty"`
+	Line            int                `json:"line,omitempty"`
+	Count           int                `json:"count"`
+	WallTime        float32            `json:"wt"`
+	ExclWallTime    float32            `json:"excl_wt"`
+	CpuTime         float32            `json:"cpu"`
+	ExclCpuTime     float32            `json:"excl_cpu"`
+	IoTime          float32            `json:"io"`
+	ExclIoTime      float32            `json:"excl_io"`
+	Memory          float32            `json:"memory"`
+	ExclMemory      float32            `json:"excl_memory"`
+	PeakMemory      float32            `json:"peak_memory"`
+	NumAlloc        float32            `json:"num_alloc"`
+	ExclNumAlloc    float32            `json:"excl_num_alloc"`
+	NumFree         float32            `json:"num_free"`
+	ExclNumFree     float32            `json:"excl_num_free"`
+	AllocAmount     float32            `json:"alloc_amt"`
+	ExclAllocAmount float32            `json:"excl_alloc_amt"`
+	Costs           map[string]float32 `json:"costs,omitempty"`
+	ExclusiveCosts  map[string]float32 `json:"excl_costs,omitempty"`
+}
+
+func newJSONCalls(calls []*xhprof.Call) []*jsonCall {
+	res := make([]*jsonCall, 0, len(calls))
+	for _, c := range calls {
+		res = append(res, &jsonCall{
+			Name:            c.Name,
+			File:            c.File,
+			Line:            c.Line,
+			Count:           c.Count,
+			WallTime:        c.WallTime,
+			ExclWallTime:    c.ExclusiveWallTime,
+			CpuTime:         c.CpuTime,
+			ExclCpuTime:     c.ExclusiveCpuTime,
+			IoTime:          c.IoTime,
+			ExclIoTime:      c.ExclusiveIoTime,
+			Memory:          c.Memory,
+			ExclMemory:      c.ExclusiveMemory,
+			PeakMemory:      c.PeakMemory,
+			NumAlloc:        c.NumAlloc,
+			ExclNumAlloc:    c.ExclusiveNumAlloc,
+			NumFree:         c.NumFree,
+			ExclNumFree:     c.ExclusiveNumFree,
+			AllocAmount:     c.AllocAmount,
+			ExclAllocAmount: c.ExclusiveAllocAmount,
+			Costs:           c.Costs,
+			ExclusiveCosts:  c.ExclusiveCosts,
+		})
+	}
+
+	return res
+}
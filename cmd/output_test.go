@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureOutput returns what f writes to stdout with the output format
+// format.
+func captureOutput(t *testing.T, format string, f func() error) string {
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+
+	stdout, previous := os.Stdout, output
+	os.Stdout, output = w, format
+	defer func() {
+		os.Stdout, output = stdout, previous
+	}()
+
+	require.Nil(t, f())
+	w.Close()
+
+	out, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+
+	return string(out)
+}
+
+func TestRenderTable(t *testing.T) {
+	headers := []string{"Function", "Wall-Time (ms)"}
+	rows := [][]string{{"main()", "1.00"}, {"foo, \"bar\" | baz", "0.50"}}
+	render := func() error { return renderTable(headers, rows) }
+
+	assert.Equal(t, "Function,Wall-Time (ms)\nmain(),1.00\n\"foo, \"\"bar\"\" | baz\",0.50\n", captureOutput(t, "csv", render))
+	assert.Equal(t, "Function\tWall-Time (ms)\nmain()\t1.00\n\"foo, \"\"bar\"\" | baz\"\t0.50\n", captureOutput(t, "tsv", render))
+
+	// Pipes in cells are escaped so that they don't end the cell.
+	assert.Equal(t, `|     Function      | Wall-Time (ms) |
+|-------------------|----------------|
+| main()            |           1.00 |
+| foo, "bar" \| baz |           0.50 |
+`, captureOutput(t, "markdown", render))
+
+	assert.Contains(t, captureOutput(t, "table", render), "+-")
+}
+
+func TestRenderJSON(t *testing.T) {
+	calls := newJSONCalls([]*xhprof.Call{
+		{Name: "<b>", Count: 2, WallTime: 500, ExclusiveWallTime: 300, Costs: map[string]float32{"Ir": 10}},
+	})
+
+	// HTML is not escaped, and costs are only added if there are any.
+	assert.Equal(t, `[
+  {
+    "name": "<b>",
+    "count": 2,
+    "wt": 500,
+    "excl_wt": 300,
+    "cpu": 0,
+    "excl_cpu": 0,
+    "io": 0,
+    "excl_io": 0,
+    "memory": 0,
+    "excl_memory": 0,
+    "peak_memory": 0,
+    "num_alloc": 0,
+    "excl_num_alloc": 0,
+    "num_free": 0,
+    "excl_num_free": 0,
+    "alloc_amt": 0,
+    "excl_alloc_amt": 0,
+    "costs": {
+      "Ir": 10
+    }
+  }
+]
+`, captureOutput(t, "json", func() error { return renderJSON(calls) }))
+}
+
+func TestFormatOutput(t *testing.T) {
+	defer func(previous string) { output = previous }(output)
+
+	for format, expected := range map[string][2]string{
+		"table":    {"1.50 ms", "Wall-Time"},
+		"markdown": {"1.50 ms", "Wall-Time"},
+		"csv":      {"1.50", "Wall-Time (ms)"},
+		"tsv":      {"1.50", "Wall-Time (ms)"},
+		"json":     {"1.50", "Wall-Time (ms)"},
+	} {
+		output = format
+		assert.Nil(t, validateOutput(), format)
+		assert.Equal(t, expected[0], formatValue(1500, ms), format)
+		assert.Equal(t, expected[1], formatHeader("Wall-Time", ms), format)
+	}
+
+	output = "xml"
+	assert.NotNil(t, validateOutput())
+}
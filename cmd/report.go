@@ -0,0 +1,464 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().StringVarP(&flamegraphDimension, "dimension", "d", "wt", "Inclusive dimension used for the width of the flame graph frames (wt, cpu, memory, num_alloc, num_free, alloc_amt)")
+	reportCmd.Flags().Float32VarP(&threshold, "threshold", "t", 1, "Display items having greater ratio of wt (default 1%) with respect to main() in the graphs")
+	reportCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	reportCmd.Flags().StringVarP(&includePattern, "include", "", "", includeUsage)
+	reportCmd.Flags().StringVarP(&excludePattern, "exclude", "", "", excludeUsage)
+	reportCmd.Flags().StringVarP(&focusPattern, "focus", "", "", focusUsage)
+	reportCmd.Flags().StringVarP(&ignorePattern, "ignore", "", "", ignoreUsage)
+	reportCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	reportCmd.Flags().StringVarP(&groupBy, "group-by", "", "", groupByUsage)
+	reportCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+	reportCmd.Flags().StringVarP(&outFile, "out-file", "o", "", "The path to store the resulting HTML file (default \"report.html\")")
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report filepaths...",
+	Short: "Render profiles of any supported format into a single self-contained HTML file.",
+	Long: `Render profiles of any supported format into a single self-contained HTML file.
+
+The report contains a sortable table of all functions, their parents and
+children, a flame graph, the call graph and the meta data of the profiles.
+Multiple profiles are combined with --aggregate. The call graph is rendered with the dot
+command of graphviz if it is installed, and included as dot script otherwise.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: report,
+}
+
+type reportPage struct {
+	Title      string
+	Aggregate  string
+	Links      []reportLink
+	Generated  string
+	Version    string
+	Inputs     []*reportInput
+	Columns    []FieldInfo
+	SortColumn int
+	Calls      []*reportCall
+	Family     map[string]*reportFamily
+	FlameGraph template.URL
+	FlameLinks []reportLink
+	CallGraph  template.URL
+	DotScript  string
+}
+
+// reportLink is a link in the navigation of a page, which reports written to
+// files don't have.
+type reportLink struct {
+	Label string
+	URL   string
+}
+
+// reportInput describes a profile file that is part of a report.
+type reportInput struct {
+	Path   string
+	Format string
+	Runs   int
+	Run    string
+	Meta   [][2]string
+}
+
+type reportCall struct {
+	Name     string
+	Location string
+	Count    int
+	Values   []reportValue
+}
+
+type reportValue struct {
+	Raw  float32
+	Text string
+}
+
+// reportFamily holds the parents and children of a function, like
+// xhprof.NearestFamily, for the drill-down of reports.
+type reportFamily struct {
+	Parents  []*reportRelative `json:"parents"`
+	Children []*reportRelative `json:"children"`
+}
+
+type reportRelative struct {
+	Name     string  `json:"name"`
+	Count    int     `json:"count"`
+	WallTime float32 `json:"wt"`
+}
+
+func report(cmd *cobra.Command, args []string) error {
+	fieldInfo, err := getFlameGraphFieldInfo(flamegraphDimension)
+	if err != nil {
+		return err
+	}
+
+	inputs := make([]*reportInput, 0, len(args))
+	loaded := make([]*xhprof.PairCallMap, 0, len(args))
+	for _, arg := range args {
+		input, maps, err := loadReportInput(arg)
+		if err != nil {
+			return err
+		}
+
+		inputs = append(inputs, input)
+		loaded = append(loaded, maps...)
+	}
+
+	avgMap, profile, err := aggregateMaps(loaded, aggregate)
+	if err != nil {
+		return err
+	}
+
+	threshold /= 100
+	page, err := newReportPage(avgMap, profile, fieldInfo, threshold)
+	if err != nil {
+		return err
+	}
+
+	page.Title = "Profile of " + strings.Join(args, ", ")
+	if len(inputs) == 1 && inputs[0].Run != "" {
+		page.Title = "Profile of " + inputs[0].Run
+	}
+	page.Inputs = inputs
+
+	var b bytes.Buffer
+	if err = reportTemplate.Execute(&b, page); err != nil {
+		return err
+	}
+
+	if len(outFile) == 0 {
+		outFile = "report.html"
+	}
+
+	err = writeOutFile(outFile, b.Bytes())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Written report to HTML file: %s\n", outFile)
+
+	return nil
+}
+
+// loadReportInput reads the profiles of path, grouped by --group-by, along
+// with the description of the file shown in reports.
+func loadReportInput(path string) (*reportInput, []*xhprof.PairCallMap, error) {
+	format, err := xhprof.NewFile(path, inputFormat).DetectFormat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	maps, err := loadPairCallMaps([]string{path}, format, parts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if maps, err = groupMaps(maps, groupBy); err != nil {
+		return nil, nil, err
+	}
+
+	input := &reportInput{Path: path, Format: format, Runs: len(maps)}
+	if len(maps) == 1 {
+		input.Run = describeRun(maps[0])
+
+		keys := make([]string, 0, len(maps[0].Meta))
+		for k := range maps[0].Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			input.Meta = append(input.Meta, [2]string{k, maps[0].Meta[k]})
+		}
+	}
+
+	return input, maps, nil
+}
+
+// newReportPage computes the tables and graphs of a report for the map and
+// its profile, as returned by aggregateMaps, with the flame graph by fieldInfo and both graphs limited by threshold (a ratio).
+func newReportPage(m *xhprof.PairCallMap, profile *xhprof.Profile, fieldInfo FieldInfo, threshold float32) (*reportPage, error) {
+	if profile.Main == nil {
+		return nil, fmt.Errorf("Profile has no main()")
+	}
+
+	page := &reportPage{
+		Generated: time.Now().Format(time.RFC1123),
+		Version:   version,
+		Aggregate: aggregate,
+		Family:    getReportFamilies(m),
+	}
+
+	page.Columns = getDimensions(profile)
+	for i, column := range page.Columns {
+		if column == fieldsMap["excl_wt"] {
+			page.SortColumn = i
+		}
+	}
+
+	if len(page.Columns) > 0 {
+		profile.SortBy(page.Columns[page.SortColumn].Name)
+	}
+
+	for _, call := range profile.Calls {
+		c := &reportCall{
+			Name:     call.Name,
+			Location: formatLocation(call.File, call.Line),
+			Count:    call.Count,
+			Values:   make([]reportValue, 0, len(page.Columns)),
+		}
+
+		for _, column := range page.Columns {
+			value := call.GetFloat32Field(column.Name)
+			c.Values = append(c.Values, reportValue{Raw: value, Text: formatNumber(value, column.Unit)})
+		}
+
+		page.Calls = append(page.Calls, c)
+	}
+
+	title := fmt.Sprintf("Flame Graph by %s", fieldInfo.Label)
+	svg, err := xhprof.GenerateFlameGraph(m, fieldInfo.Name, title, fieldInfo.Unit.Name, fieldInfo.Unit.Divisor, threshold)
+	if err != nil {
+		return nil, err
+	}
+	page.FlameGraph = svgDataURL([]byte(svg))
+
+	dot, err := xhprof.GenerateDotScript(m, threshold, "", false, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if svg, err := renderDotScript(dot); err == nil {
+		page.CallGraph = svgDataURL(svg)
+	} else {
+		page.DotScript = dot
+	}
+
+	return page, nil
+}
+
+// getReportFamilies returns the parents and children of all functions of the
+// map, sorted by their wall time.
+func getReportFamilies(m *xhprof.PairCallMap) map[string]*reportFamily {
+	families := make(map[string]*reportFamily)
+	for name, family := range m.ComputeNearestFamilies() {
+		families[name] = &reportFamily{
+			Parents:  getReportRelatives(family.Parents),
+			Children: getReportRelatives(family.Children),
+		}
+	}
+
+	return families
+}
+
+// getReportRelatives returns the relatives of a family sorted by their wall
+// time.
+func getReportRelatives(m *xhprof.PairCallMap) []*reportRelative {
+	relatives := make([]*reportRelative, 0, len(m.M))
+	for name, info := range m.M {
+		relatives = append(relatives, &reportRelative{Name: name, Count: info.Count, WallTime: info.WallTime})
+	}
+
+	sort.Slice(relatives, func(i, j int) bool {
+		return relatives[i].WallTime > relatives[j].WallTime
+	})
+
+	return relatives
+}
+
+// renderDotScript renders a dot script to SVG with the dot command of
+// graphviz, which fails if graphviz is not installed.
+func renderDotScript(dot string) ([]byte, error) {
+	path, err := exec.LookPath("dot")
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	c := exec.Command(path, "-Tsvg")
+	c.Stdin = strings.NewReader(dot)
+	c.Stdout = &stdout
+	if err = c.Run(); err != nil {
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func svgDataURL(svg []byte) template.URL {
+	return template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg))
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(reportHTML))
+
+// reportStyle is the CSS of reports and of the pages of serve.
+const reportStyle = `body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 14px; margin: 0 2em 2em; color: #222; }
+h1 { font-size: 22px; margin: 1em 0 0.2em; }
+h2 { font-size: 18px; margin: 1.5em 0 0.5em; border-bottom: 1px solid #ddd; }
+nav a { margin-right: 1em; }
+.muted { color: #777; }
+table { border-collapse: collapse; }
+th, td { padding: 3px 8px; border-bottom: 1px solid #eee; text-align: right; white-space: nowrap; }
+th { background: #f4f4f4; }
+table.sortable th { cursor: pointer; user-select: none; }
+th.sorted::after { content: " \25BC"; font-size: 10px; }
+th.sorted.asc::after { content: " \25B2"; }
+td.name, th.name { text-align: left; }
+td.name a { color: #0645ad; text-decoration: none; }
+td.name a:hover { text-decoration: underline; }
+.location { color: #777; font-size: 12px; text-align: left; }
+.better { color: #080; }
+.worse { color: #c00; }
+tr:hover td { background: #fafae0; }
+#filter { margin-bottom: 0.5em; padding: 3px; width: 25em; }
+#family { display: none; margin: 1em 0; padding: 0.5em 1em 1em; background: #f8f8ff; border: 1px solid #dde; }
+#family table { display: inline-table; vertical-align: top; margin-right: 2em; }
+.graph { max-width: 100%; border: 1px solid #eee; }
+pre { background: #f8f8f8; padding: 1em; overflow: auto; max-height: 30em; }
+`
+
+const reportHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+` + reportStyle + `</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">Generated {{.Generated}}{{if .Version}} by tk {{.Version}}{{end}}</p>
+<nav>{{range .Links}}<a href="{{.URL}}">{{.Label}}</a>{{end}}<a href="#functions">Functions</a><a href="#flamegraph">Flame Graph</a><a href="#callgraph">Call Graph</a><a href="#profiles">Profiles</a></nav>
+
+<h2 id="functions">Functions</h2>
+<input id="filter" type="search" placeholder="Filter functions">
+<div id="family"></div>
+<table id="calls" class="sortable">
+<thead><tr><th class="name" data-type="name">Function</th><th data-type="num">Count</th>{{range .Columns}}<th data-type="num" title="{{.Label}}">{{.Label}}{{if .Unit.Name}} ({{.Unit.Name}}){{end}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Calls}}<tr><td class="name" data-v="{{.Name}}"><a href="#" data-fn="{{.Name}}">{{.Name}}</a>{{if .Location}}<div class="location">{{.Location}}</div>{{end}}</td><td data-v="{{.Count}}">{{.Count}}</td>{{range .Values}}<td data-v="{{.Raw}}">{{.Text}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+
+<h2 id="flamegraph">Flame Graph</h2>
+{{if .FlameLinks}}<p>By {{range .FlameLinks}}<a href="{{.URL}}">{{.Label}}</a> {{end}}</p>
+{{end}}<object class="graph" type="image/svg+xml" data="{{.FlameGraph}}"></object>
+
+<h2 id="callgraph">Call Graph</h2>
+{{if .CallGraph}}<img class="graph" src="{{.CallGraph}}" alt="Call Graph">
+{{else}}<p class="muted">Graphviz is not installed, render the call graph with <code>dot -Tsvg callgraph.dot &gt; callgraph.svg</code>.</p>
+<pre>{{.DotScript}}</pre>
+{{end}}
+<h2 id="profiles">Profiles</h2>
+{{range .Inputs}}<h3>{{.Path}}</h3>
+<table>
+<tr><td class="name">Format</td><td class="name">{{.Format}}</td></tr>
+{{if gt .Runs 1}}<tr><td class="name">Runs</td><td class="name">{{.Runs}} ({{$.Aggregate}})</td></tr>{{end}}
+{{if .Run}}<tr><td class="name">Request</td><td class="name">{{.Run}}</td></tr>{{end}}
+{{range .Meta}}<tr><td class="name">{{index . 0}}</td><td class="name">{{index . 1}}</td></tr>
+{{end}}</table>
+{{end}}
+<script>
+` + tableScript + `
+var family = {{.Family}};
+sortableTable(document.getElementById("calls"), {{.SortColumn}} + 2);
+filterableTable(document.getElementById("calls"), document.getElementById("filter"));
+` + familyScript + `</script>
+</body>
+</html>
+`
+
+// tableScript makes the columns of tables sortable by clicking on them, with
+// the values to sort by in the data-v attribute of each cell.
+const tableScript = `function sortableTable(table, sortColumn) {
+	var tbody = table.tBodies[0];
+	var headers = table.tHead.rows[0].cells;
+	var sorted = { col: sortColumn, asc: false };
+
+	function sortBy(col, asc) {
+		var numeric = headers[col].getAttribute("data-type") === "num";
+		var rows = Array.prototype.slice.call(tbody.rows);
+		rows.sort(function(a, b) {
+			var x = a.cells[col].getAttribute("data-v"), y = b.cells[col].getAttribute("data-v");
+			var r = numeric ? parseFloat(x) - parseFloat(y) : x.localeCompare(y);
+			return asc ? r : -r;
+		});
+		rows.forEach(function(row) { tbody.appendChild(row); });
+
+		for (var i = 0; i < headers.length; i++) {
+			headers[i].className = headers[i].className.replace(/ ?sorted( asc)?/, "");
+		}
+		headers[col].className += asc ? " sorted asc" : " sorted";
+		sorted = { col: col, asc: asc };
+	}
+
+	Array.prototype.forEach.call(headers, function(th, col) {
+		th.addEventListener("click", function() {
+			sortBy(col, sorted.col === col ? !sorted.asc : col === 0);
+		});
+	});
+	headers[sortColumn].className += " sorted";
+}
+
+function filterableTable(table, input) {
+	input.addEventListener("input", function(e) {
+		var q = e.target.value.toLowerCase();
+		Array.prototype.forEach.call(table.tBodies[0].rows, function(row) {
+			row.style.display = row.cells[0].getAttribute("data-v").toLowerCase().indexOf(q) >= 0 ? "" : "none";
+		});
+	});
+}
+`
+
+// familyScript shows the parents and children of a function when clicking on
+// it, from the family variable holding the reportFamily of all functions.
+const familyScript = `(function() {
+	function escape(s) {
+		return s.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;");
+	}
+
+	function relatives(title, list) {
+		var html = "<table><thead><tr><th class=\"name\">" + title + "</th><th>Count</th><th>Wall-Time (ms)</th></tr></thead><tbody>";
+		if (list.length === 0) {
+			html += "<tr><td class=\"name muted\" colspan=\"3\">None</td></tr>";
+		}
+		list.forEach(function(r) {
+			html += "<tr><td class=\"name\"><a href=\"#\" data-fn=\"" + escape(r.name) + "\">" + escape(r.name) + "</a></td><td>" + r.count + "</td><td>" + (r.wt / 1000).toFixed(2) + "</td></tr>";
+		});
+		return html + "</tbody></table>";
+	}
+
+	function show(name) {
+		var f = family[name];
+		if (!f) return;
+
+		var el = document.getElementById("family");
+		el.innerHTML = "<h3>" + escape(name) + "</h3>" + relatives("Parents", f.parents) + relatives("Children", f.children);
+		el.style.display = "block";
+		el.scrollIntoView();
+	}
+
+	document.addEventListener("click", function(e) {
+		var fn = e.target.getAttribute && e.target.getAttribute("data-fn");
+		if (fn === null || fn === undefined) return;
+
+		e.preventDefault();
+		show(fn);
+	});
+})();
+`
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReportTestMap() *xhprof.PairCallMap {
+	m := xhprof.NewPairCallMap()
+	m.M["main()"] = &xhprof.PairCall{Count: 1, WallTime: 1000}
+	m.M["main()==>foo"] = &xhprof.PairCall{Count: 2, WallTime: 500}
+	m.M["main()==>bar"] = &xhprof.PairCall{Count: 1, WallTime: 50}
+	m.M["foo==>bar"] = &xhprof.PairCall{Count: 10, WallTime: 300}
+
+	return m
+}
+
+func TestNewReportPage(t *testing.T) {
+	m := newReportTestMap()
+	page, err := newReportPage(m, m.Flatten(), fieldsMap["wt"], 0.01)
+	require.Nil(t, err)
+
+	require.Equal(t, []FieldInfo{fieldsMap["wt"], fieldsMap["excl_wt"]}, page.Columns)
+	assert.Equal(t, 1, page.SortColumn)
+	assert.NotEmpty(t, page.FlameGraph)
+
+	// The calls are sorted by exclusive wall time.
+	require.Len(t, page.Calls, 3)
+	assert.Equal(t, &reportCall{
+		Name:  "main()",
+		Count: 1,
+		Values: []reportValue{
+			{Raw: 1000, Text: "1.00"},
+			{Raw: 450, Text: "0.45"},
+		},
+	}, page.Calls[0])
+	assert.Equal(t, &reportCall{
+		Name:  "bar",
+		Count: 11,
+		Values: []reportValue{
+			{Raw: 350, Text: "0.35"},
+			{Raw: 350, Text: "0.35"},
+		},
+	}, page.Calls[1])
+	assert.Equal(t, "foo", page.Calls[2].Name)
+	assert.Equal(t, float32(200), page.Calls[2].Values[1].Raw)
+
+	_, err = newReportPage(xhprof.NewPairCallMap(), xhprof.NewPairCallMap().Flatten(), fieldsMap["wt"], 0.01)
+	assert.NotNil(t, err)
+}
+
+func TestGetReportFamilies(t *testing.T) {
+	families := getReportFamilies(newReportTestMap())
+
+	assert.Len(t, families, 3)
+	assert.Equal(t, &reportFamily{
+		Parents: []*reportRelative{},
+		Children: []*reportRelative{
+			{Name: "foo", Count: 2, WallTime: 500},
+			{Name: "bar", Count: 1, WallTime: 50},
+		},
+	}, families["main()"])
+
+	// Relatives are sorted by wall time.
+	assert.Equal(t, &reportFamily{
+		Parents: []*reportRelative{
+			{Name: "foo", Count: 10, WallTime: 300},
+			{Name: "main()", Count: 1, WallTime: 50},
+		},
+		Children: []*reportRelative{},
+	}, families["bar"])
+
+	// Calls of a group to itself only correct its inclusive costs.
+	m := newReportTestMap()
+	m.M["foo==>foo"] = &xhprof.PairCall{Count: -1, WallTime: -100}
+	families = getReportFamilies(m)
+
+	assert.Equal(t, []*reportRelative{{Name: "main()", Count: 2, WallTime: 500}}, families["foo"].Parents)
+	assert.Equal(t, []*reportRelative{{Name: "bar", Count: 10, WallTime: 300}}, families["foo"].Children)
+}
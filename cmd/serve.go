@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&serveDir, "dir", "", ".", "The directory containing the profiles")
+	serveCmd.Flags().StringVarP(&listen, "listen", "l", "127.0.0.1:8080", "The address to listen on")
+	serveCmd.Flags().Float32VarP(&threshold, "threshold", "t", 1, "Display items having greater ratio of wt (default 1%) with respect to main() in the graphs")
+	serveCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	serveCmd.Flags().StringVarP(&includePattern, "include", "", "", includeUsage)
+	serveCmd.Flags().StringVarP(&excludePattern, "exclude", "", "", excludeUsage)
+	serveCmd.Flags().StringVarP(&focusPattern, "focus", "", "", focusUsage)
+	serveCmd.Flags().StringVarP(&ignorePattern, "ignore", "", "", ignoreUsage)
+	serveCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	serveCmd.Flags().StringVarP(&groupBy, "group-by", "", "", groupByUsage)
+	serveCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+}
+
+var (
+	serveDir string
+	listen   string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start a local web server to browse and compare the profiles of a directory.",
+	Long: `Start a local web server to browse and compare the profiles of a directory.
+
+All profiles of the directory and its subdirectories are listed, newest first.
+The page of a profile has the same content as the output of the report
+command, and any two profiles can be compared. Profiles are read again on
+every request, so new profiles show up without restarting the server.`,
+	Args: cobra.NoArgs,
+	RunE: serve,
+}
+
+// flameGraphDimensions are the dimensions flame graphs can be viewed by on the
+// pages of serve, if the profile has data for them.
+var flameGraphDimensions = []string{"wt", "cpu", "memory", "num_alloc", "num_free", "alloc_amt"}
+
+// profileServer serves the pages of the profiles in dir.
+type profileServer struct {
+	dir string
+}
+
+func serve(cmd *cobra.Command, args []string) error {
+	info, err := os.Stat(serveDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", serveDir)
+	}
+
+	s := &profileServer{dir: serveDir}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.index)
+	mux.HandleFunc("/profile", s.profile)
+	mux.HandleFunc("/compare", s.compare)
+
+	fmt.Printf("Serving profiles of %s on http://%s/\n", serveDir, listen)
+
+	return http.ListenAndServe(listen, mux)
+}
+
+type serveFile struct {
+	Path     string
+	Format   string
+	Size     string
+	Modified string
+
+	modTime time.Time
+}
+
+type serveIndexPage struct {
+	Dir   string
+	Files []*serveFile
+}
+
+func (s *profileServer) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	files := make([]*serveFile, 0)
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && path != s.dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		format, err := xhprof.NewFile(path, inputFormat).DetectFormat()
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &serveFile{
+			Path:     filepath.ToSlash(rel),
+			Format:   format,
+			Size:     fmt.Sprintf("%.1f KB", float64(info.Size())/1024),
+			Modified: info.ModTime().Format("2006-01-02 15:04:05"),
+			modTime:  info.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	s.render(w, serveIndexTemplate, &serveIndexPage{Dir: s.dir, Files: files})
+}
+
+func (s *profileServer) profile(w http.ResponseWriter, r *http.Request) {
+	rel := r.URL.Query().Get("file")
+	path, err := s.resolve(rel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	dimension := r.URL.Query().Get("dimension")
+	if dimension == "" {
+		dimension = "wt"
+	}
+
+	fieldInfo, err := getFlameGraphFieldInfo(dimension)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	input, maps, err := loadReportInput(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	input.Path = rel
+
+	avgMap, profile, err := aggregateMaps(maps, aggregate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, err := newReportPage(avgMap, profile, fieldInfo, threshold/100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page.Title = "Profile of " + rel
+	if input.Run != "" {
+		page.Title = "Profile of " + input.Run
+	}
+	page.Inputs = []*reportInput{input}
+	page.Links = []reportLink{{Label: "All profiles", URL: "/"}}
+
+	main := avgMap.M["main()"]
+	for _, d := range flameGraphDimensions {
+		if d == dimension || main.GetFloat32Field(fieldsMap[d].Name) == 0 {
+			continue
+		}
+
+		page.FlameLinks = append(page.FlameLinks, reportLink{
+			Label: fieldsMap[d].Label,
+			URL:   "/profile?" + url.Values{"file": {rel}, "dimension": {d}}.Encode() + "#flamegraph",
+		})
+	}
+
+	s.render(w, reportTemplate, page)
+}
+
+type serveComparePage struct {
+	From      *reportInput
+	To        *reportInput
+	Calls     []*serveCallDiff
+	CallGraph template.URL
+	DotScript string
+}
+
+type serveCallDiff struct {
+	Name           string
+	Count          int
+	WallTime       reportValue
+	FractionWtFrom float32
+	FractionWtTo   float32
+	Change         float32
+}
+
+func (s *profileServer) compare(w http.ResponseWriter, r *http.Request) {
+	inputs := make([]*reportInput, 0, 2)
+	loaded := make([]*xhprof.PairCallMap, 0, 2)
+	profiles := make([]*xhprof.Profile, 0, 2)
+	for _, param := range []string{"from", "to"} {
+		rel := r.URL.Query().Get(param)
+		path, err := s.resolve(rel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		input, maps, err := loadReportInput(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		input.Path = rel
+
+		avgMap, profile, err := aggregateMaps(maps, aggregate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		inputs = append(inputs, input)
+		loaded = append(loaded, avgMap)
+		profiles = append(profiles, profile)
+	}
+
+	diff := profiles[0].Subtract(profiles[1])
+
+	page := &serveComparePage{From: inputs[0], To: inputs[1]}
+	for _, call := range diff.Calls {
+		page.Calls = append(page.Calls, &serveCallDiff{
+			Name:           call.Name,
+			Count:          call.Count,
+			WallTime:       reportValue{Raw: call.WallTime, Text: formatNumber(call.WallTime, ms)},
+			FractionWtFrom: call.FractionWtFrom,
+			FractionWtTo:   call.FractionWtTo,
+			Change:         call.FractionWtTo - call.FractionWtFrom,
+		})
+	}
+
+	// The functions that got slower compared to main() come first.
+	sort.SliceStable(page.Calls, func(i, j int) bool {
+		return page.Calls[i].Change > page.Calls[j].Change
+	})
+
+	dot, err := xhprof.GenerateDiffDotScript(loaded[0], loaded[1], threshold/100, "", true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if svg, err := renderDotScript(dot); err == nil {
+		page.CallGraph = svgDataURL(svg)
+	} else {
+		page.DotScript = dot
+	}
+
+	s.render(w, serveCompareTemplate, page)
+}
+
+// resolve returns the path of a profile given relative to the directory of
+// the server, refusing paths outside of it.
+func (s *profileServer) resolve(rel string) (string, error) {
+	if rel == "" {
+		return "", errors.New("No profile given")
+	}
+
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Profile %s is outside of %s", rel, s.dir)
+	}
+
+	path := filepath.Join(s.dir, clean)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("Profile %s does not exist", rel)
+	}
+
+	return path, nil
+}
+
+func (s *profileServer) render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+var serveIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Profiles of {{.Dir}}</title>
+<style>
+` + reportStyle + `</style>
+</head>
+<body>
+<h1>Profiles of {{.Dir}}</h1>
+{{if .Files}}
+<form action="/compare" method="get">
+<p>Compare <select name="from">{{range .Files}}<option>{{.Path}}</option>{{end}}</select>
+with <select name="to">{{range .Files}}<option>{{.Path}}</option>{{end}}</select>
+<button type="submit">Compare</button></p>
+</form>
+<input id="filter" type="search" placeholder="Filter profiles">
+<table id="files" class="sortable">
+<thead><tr><th class="name" data-type="name">Profile</th><th class="name" data-type="name">Format</th><th data-type="num">Size</th><th data-type="name">Modified</th></tr></thead>
+<tbody>
+{{range .Files}}<tr><td class="name" data-v="{{.Path}}"><a href="/profile?file={{.Path}}">{{.Path}}</a></td><td class="name" data-v="{{.Format}}">{{.Format}}</td><td data-v="{{.Size}}">{{.Size}}</td><td data-v="{{.Modified}}">{{.Modified}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+` + tableScript + `
+sortableTable(document.getElementById("files"), 3);
+filterableTable(document.getElementById("files"), document.getElementById("filter"));
+</script>
+{{else}}<p class="muted">The directory contains no profiles.</p>
+{{end}}</body>
+</html>
+`))
+
+var serveCompareTemplate = template.Must(template.New("compare").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Comparison of {{.From.Path}} and {{.To.Path}}</title>
+<style>
+` + reportStyle + `</style>
+</head>
+<body>
+<h1>Comparison of {{.From.Path}} and {{.To.Path}}</h1>
+<nav><a href="/">All profiles</a><a href="/profile?file={{.From.Path}}">{{.From.Path}}</a><a href="/profile?file={{.To.Path}}">{{.To.Path}}</a><a href="#functions">Functions</a><a href="#callgraph">Call Graph</a></nav>
+{{if .From.Run}}<p>From: {{.From.Run}}</p>{{end}}
+{{if .To.Run}}<p>To: {{.To.Run}}</p>{{end}}
+
+<h2 id="functions">Functions</h2>
+<p class="muted">The difference of the wall time of each function, and its fraction of the wall time of main() in both profiles.</p>
+<input id="filter" type="search" placeholder="Filter functions">
+<table id="calls" class="sortable">
+<thead><tr><th class="name" data-type="name">Function</th><th data-type="num">Count</th><th data-type="num">Wall-Time (ms)</th><th data-type="num">Fraction Wall-Time From</th><th data-type="num">Fraction Wall-Time To</th><th data-type="num">Change</th></tr></thead>
+<tbody>
+{{range .Calls}}<tr><td class="name" data-v="{{.Name}}">{{.Name}}</td><td data-v="{{.Count}}">{{.Count}}</td><td data-v="{{.WallTime.Raw}}">{{.WallTime.Text}}</td><td data-v="{{.FractionWtFrom}}">{{printf "%.2f" .FractionWtFrom}}</td><td data-v="{{.FractionWtTo}}">{{printf "%.2f" .FractionWtTo}}</td><td data-v="{{.Change}}" class="{{if gt .Change 0.0}}worse{{else if lt .Change 0.0}}better{{end}}">{{printf "%+.2f" .Change}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<h2 id="callgraph">Call Graph</h2>
+{{if .CallGraph}}<img class="graph" src="{{.CallGraph}}" alt="Call Graph">
+{{else}}<p class="muted">Graphviz is not installed, render the call graph with <code>dot -Tsvg callgraph.dot &gt; callgraph.svg</code>.</p>
+<pre>{{.DotScript}}</pre>
+{{end}}<script>
+` + tableScript + `
+sortableTable(document.getElementById("calls"), 5);
+filterableTable(document.getElementById("calls"), document.getElementById("filter"));
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileServerResolve(t *testing.T) {
+	parent, err := ioutil.TempDir("", "toolkit")
+	require.Nil(t, err)
+	defer os.RemoveAll(parent)
+
+	dir := filepath.Join(parent, "profiles")
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "sub", "a.xhprof"), []byte("{}"), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(parent, "secret.xhprof"), []byte("{}"), 0644))
+
+	s := &profileServer{dir: dir}
+
+	path, err := s.resolve("sub/a.xhprof")
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "sub", "a.xhprof"), path)
+
+	path, err = s.resolve("sub/../sub/./a.xhprof")
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "sub", "a.xhprof"), path)
+
+	for _, rel := range []string{
+		"",
+		"..",
+		"../secret.xhprof",
+		"sub/../../secret.xhprof",
+		filepath.Join(parent, "secret.xhprof"),
+		filepath.ToSlash(filepath.Join(dir, "sub", "a.xhprof")),
+		"sub",
+		"missing.xhprof",
+	} {
+		_, err := s.resolve(rel)
+		assert.NotNil(t, err, rel)
+	}
+}
@@ -0,0 +1,513 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tideways/toolkit/xhprof"
+
+	"github.com/nsf/termbox-go"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(tuiCmd)
+	tuiCmd.Flags().StringVarP(&field, "dimension", "d", "excl_wt", "Dimension to sort by at start (wt, excl_wt, cpu, excl_cpu, memory, excl_memory, io, excl_io, num_alloc, num_free, alloc_amt, or any other event of callgrind files like Ir, excl_Ir)")
+	tuiCmd.Flags().StringVarP(&inputFormat, "format", "", "auto", inputFormatUsage)
+	tuiCmd.Flags().StringVarP(&includePattern, "include", "", "", includeUsage)
+	tuiCmd.Flags().StringVarP(&excludePattern, "exclude", "", "", excludeUsage)
+	tuiCmd.Flags().StringVarP(&focusPattern, "focus", "", "", focusUsage)
+	tuiCmd.Flags().StringVarP(&ignorePattern, "ignore", "", "", ignoreUsage)
+	tuiCmd.Flags().StringVarP(&aggregate, "aggregate", "", "mean", aggregateUsage)
+	tuiCmd.Flags().StringVarP(&groupBy, "group-by", "", "", groupByUsage)
+	tuiCmd.Flags().StringVarP(&parts, "parts", "", "sum", "How the parts of multi-part callgrind files are combined (sum, avg)")
+}
+
+var tuiCmd = &cobra.Command{
+	Use:   "tui filepaths...",
+	Short: "Explore profiles in an interactive terminal interface.",
+	Long: `Explore profiles in an interactive terminal interface.
+
+Lists all functions of the profile, sorted by a dimension that can be switched
+with d and D. Typing / filters the functions by name, and Enter shows the
+parents and children of the selected function, which can be followed further
+up or down the call chain. Backspace or Esc goes back, q quits. Multiple files
+are combined into one profile with --aggregate.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: tui,
+}
+
+// tuiHelp is the status line of the terminal interface when not filtering.
+const tuiHelp = "↑↓ select  Enter parents/children  Esc back  / filter  d/D dimension  q quit"
+
+// tuiView is a screen of the terminal interface, which is the list of all
+// functions or the parents and children of function.
+type tuiView struct {
+	function string
+	filter   string
+	selected int
+	offset   int
+}
+
+// tuiRow is a line of the table of a view. Titles separate the parents from
+// the children of a function and cannot be selected.
+type tuiRow struct {
+	Name    string
+	Count   int
+	Value   float32
+	Percent float32
+	Title   bool
+}
+
+// tuiState holds the profile explored in the terminal interface and the views
+// opened so far, with the current one last.
+type tuiState struct {
+	m          *xhprof.PairCallMap
+	profile    *xhprof.Profile
+	run        string
+	dimensions []FieldInfo
+	dimension  int
+	filtering  bool
+	views      []*tuiView
+	rows       []*tuiRow
+}
+
+func tui(cmd *cobra.Command, args []string) error {
+	maps, err := loadPairCallMaps(args, inputFormat, parts)
+	if err != nil {
+		return err
+	}
+
+	if maps, err = groupMaps(maps, groupBy); err != nil {
+		return err
+	}
+
+	avgMap, profile, err := aggregateMaps(maps, aggregate)
+	if err != nil {
+		return err
+	}
+
+	t, err := newTuiState(avgMap, profile, strings.Join(args, ", "))
+	if err != nil {
+		return err
+	}
+
+	if err = termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+
+	for {
+		t.draw()
+
+		switch ev := termbox.PollEvent(); ev.Type {
+		case termbox.EventKey:
+			if !t.handleKey(ev) {
+				return nil
+			}
+		case termbox.EventError:
+			return ev.Err
+		}
+	}
+}
+
+func newTuiState(m *xhprof.PairCallMap, profile *xhprof.Profile, paths string) (*tuiState, error) {
+	if profile.Main == nil {
+		return nil, fmt.Errorf("Profile has no main()")
+	}
+
+	fieldInfo, ok := getFieldInfo(field, profile)
+	if !ok {
+		return nil, fmt.Errorf("Provided dimension (%s) is not valid", field)
+	}
+
+	t := &tuiState{
+		m:          m,
+		profile:    profile,
+		run:        describeRun(m),
+		dimensions: getDimensions(profile),
+		views:      []*tuiView{&tuiView{}},
+	}
+	if t.run == "" {
+		t.run = paths
+	}
+
+	t.dimension = -1
+	for i, info := range t.dimensions {
+		if info == fieldInfo {
+			t.dimension = i
+		}
+	}
+
+	// The dimension was asked for, so it is offered even if the profile has
+	// no data for it.
+	if t.dimension == -1 {
+		t.dimensions = append([]FieldInfo{fieldInfo}, t.dimensions...)
+		t.dimension = 0
+	}
+
+	t.update()
+
+	return t, nil
+}
+
+func (t *tuiState) view() *tuiView {
+	return t.views[len(t.views)-1]
+}
+
+func (t *tuiState) fieldInfo() FieldInfo {
+	return t.dimensions[t.dimension]
+}
+
+// update computes the rows of the current view, and moves the selection to
+// the nearest function if it is on a title or past the last row.
+func (t *tuiState) update() {
+	v := t.view()
+	if v.function == "" {
+		t.rows = t.getFunctionRows(v.filter)
+	} else {
+		t.rows = t.getFamilyRows(v.function, v.filter)
+	}
+
+	if v.selected >= len(t.rows) {
+		v.selected = len(t.rows) - 1
+	}
+	if v.selected < 0 {
+		v.selected = 0
+	}
+
+	t.move(0)
+}
+
+// getFunctionRows returns all functions containing filter, sorted by the
+// dimension, with their share of main().
+func (t *tuiState) getFunctionRows(filter string) []*tuiRow {
+	fieldInfo := t.fieldInfo()
+	t.profile.SortBy(fieldInfo.Name)
+
+	// Exclusive values are compared with the inclusive value of main(),
+	// which is the total of the profile.
+	total := t.profile.Main.GetFloat32Field(strings.Replace(fieldInfo.Name, "Exclusive", "", 1))
+
+	rows := make([]*tuiRow, 0, len(t.profile.Calls))
+	for _, call := range t.profile.Calls {
+		if !containsFold(call.Name, filter) {
+			continue
+		}
+
+		rows = append(rows, newTuiRow(call.Name, call.Count, call.GetFloat32Field(fieldInfo.Name), total))
+	}
+
+	return rows
+}
+
+// getFamilyRows returns the parents and children of function containing
+// filter, each sorted by wall time, with their share of the wall time of
+// function.
+func (t *tuiState) getFamilyRows(function, filter string) []*tuiRow {
+	family := t.m.ComputeNearestFamily(function)
+
+	var total float32
+	if call := t.profile.GetCall(function); call != nil {
+		total = call.WallTime
+	}
+
+	rows := make([]*tuiRow, 0, len(family.Parents.M)+len(family.Children.M)+2)
+	for _, relatives := range []struct {
+		title string
+		m     *xhprof.PairCallMap
+	}{
+		{"Parents", family.Parents},
+		{"Children", family.Children},
+	} {
+		profile := relatives.m.Flatten()
+		profile.SortBy("WallTime")
+
+		rows = append(rows, &tuiRow{Name: fmt.Sprintf("%s (%d)", relatives.title, len(profile.Calls)), Title: true})
+		for _, call := range profile.Calls {
+			if !containsFold(call.Name, filter) {
+				continue
+			}
+
+			rows = append(rows, newTuiRow(call.Name, call.Count, call.WallTime, total))
+		}
+	}
+
+	return rows
+}
+
+func newTuiRow(name string, count int, value, total float32) *tuiRow {
+	row := &tuiRow{Name: name, Count: count, Value: value}
+	if total != 0 {
+		row.Percent = 100 * value / total
+	}
+
+	return row
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// move moves the selection by delta rows, skipping titles in the direction of
+// the move, or downwards if delta is 0.
+func (t *tuiState) move(delta int) {
+	v := t.view()
+	if len(t.rows) == 0 {
+		v.selected = 0
+		return
+	}
+
+	step := 1
+	if delta < 0 {
+		step = -1
+	}
+
+	selected := v.selected + delta
+	if selected < 0 {
+		selected = 0
+		step = 1
+	} else if selected >= len(t.rows) {
+		selected = len(t.rows) - 1
+		step = -1
+	}
+
+	for i := selected; i >= 0 && i < len(t.rows); i += step {
+		if !t.rows[i].Title {
+			v.selected = i
+			return
+		}
+	}
+
+	// There are no functions in the direction of the move.
+	for i := selected; i >= 0 && i < len(t.rows); i -= step {
+		if !t.rows[i].Title {
+			v.selected = i
+			return
+		}
+	}
+
+	v.selected = selected
+}
+
+// handleKey changes the state according to the key pressed, and returns false
+// if the interface is to be closed.
+func (t *tuiState) handleKey(ev termbox.Event) bool {
+	v := t.view()
+
+	if ev.Key == termbox.KeyCtrlC {
+		return false
+	}
+
+	if t.filtering {
+		filter := v.filter
+		switch ev.Key {
+		case termbox.KeyEnter:
+			t.filtering = false
+			return true
+		case termbox.KeyEsc:
+			t.filtering = false
+			filter = ""
+		case termbox.KeyBackspace, termbox.KeyBackspace2:
+			_, size := utf8.DecodeLastRuneInString(filter)
+			filter = filter[:len(filter)-size]
+		case termbox.KeySpace:
+			filter += " "
+		default:
+			if ev.Ch == 0 {
+				return t.handleNavigationKey(ev)
+			}
+
+			filter += string(ev.Ch)
+		}
+
+		if filter != v.filter {
+			v.filter = filter
+			v.selected = 0
+			t.update()
+		}
+
+		return true
+	}
+
+	switch ev.Ch {
+	case 'q':
+		return false
+	case '/':
+		t.filtering = true
+	case 'd':
+		t.dimension = (t.dimension + 1) % len(t.dimensions)
+		t.update()
+	case 'D':
+		t.dimension = (t.dimension + len(t.dimensions) - 1) % len(t.dimensions)
+		t.update()
+	case 'j':
+		t.move(1)
+	case 'k':
+		t.move(-1)
+	case 'l':
+		t.open()
+	case 'h':
+		t.back()
+	case 0:
+		return t.handleNavigationKey(ev)
+	}
+
+	return true
+}
+
+// handleNavigationKey handles the special keys, which work while filtering as
+// well.
+func (t *tuiState) handleNavigationKey(ev termbox.Event) bool {
+	_, height := termbox.Size()
+	page := height - 4
+	if page < 1 {
+		page = 1
+	}
+
+	switch ev.Key {
+	case termbox.KeyArrowDown:
+		t.move(1)
+	case termbox.KeyArrowUp:
+		t.move(-1)
+	case termbox.KeyPgdn, termbox.KeySpace:
+		t.move(page)
+	case termbox.KeyPgup:
+		t.move(-page)
+	case termbox.KeyHome:
+		t.move(-len(t.rows))
+	case termbox.KeyEnd:
+		t.move(len(t.rows))
+	case termbox.KeyEnter, termbox.KeyArrowRight:
+		t.filtering = false
+		t.open()
+	case termbox.KeyEsc, termbox.KeyBackspace, termbox.KeyBackspace2, termbox.KeyArrowLeft:
+		t.filtering = false
+		t.back()
+	}
+
+	return true
+}
+
+// open shows the parents and children of the selected function.
+func (t *tuiState) open() {
+	v := t.view()
+	if v.selected >= len(t.rows) || t.rows[v.selected].Title {
+		return
+	}
+
+	t.views = append(t.views, &tuiView{function: t.rows[v.selected].Name})
+	t.update()
+}
+
+// back returns to the previous view, with its filter and selection.
+func (t *tuiState) back() {
+	if len(t.views) == 1 {
+		return
+	}
+
+	t.views = t.views[:len(t.views)-1]
+	t.update()
+}
+
+func (t *tuiState) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	width, height := termbox.Size()
+	v := t.view()
+
+	tuiPrint(0, 0, width, termbox.ColorDefault|termbox.AttrReverse, "Profile of "+t.run)
+
+	fieldInfo := t.fieldInfo()
+	var subtitle string
+	if v.function == "" {
+		subtitle = fmt.Sprintf("%d of %d functions by %s", len(t.rows), len(t.profile.Calls), fieldInfo.Label)
+	} else {
+		fieldInfo = fieldsMap["wt"]
+		subtitle = fmt.Sprintf("Parents and children of %s by %s", v.function, fieldInfo.Label)
+		if call := t.profile.GetCall(v.function); call != nil {
+			subtitle += fmt.Sprintf(" (%s %s in %d calls)", formatNumber(call.WallTime, fieldInfo.Unit), fieldInfo.Unit.Name, call.Count)
+		}
+	}
+	tuiPrint(0, 1, width, termbox.AttrBold, subtitle)
+
+	// The columns right of the function name have a fixed width, the name
+	// gets the rest of the line.
+	header := fieldInfo.Header
+	if fieldInfo.Unit.Name != "" {
+		header += " (" + fieldInfo.Unit.Name + ")"
+	}
+	columns := fmt.Sprintf(" %10s %18s %8s", "Calls", header, "Percent")
+	nameWidth := width - utf8.RuneCountInString(columns)
+	if nameWidth < 10 {
+		nameWidth = 10
+	}
+
+	tuiPrint(0, 2, width, termbox.AttrUnderline, fmt.Sprintf("%-*s%s", nameWidth, "Function", columns))
+
+	rowsHeight := height - 4
+	if v.selected < v.offset {
+		v.offset = v.selected
+	} else if rowsHeight > 0 && v.selected >= v.offset+rowsHeight {
+		v.offset = v.selected - rowsHeight + 1
+	}
+
+	for i := 0; i < rowsHeight && v.offset+i < len(t.rows); i++ {
+		row := t.rows[v.offset+i]
+		y := 3 + i
+
+		if row.Title {
+			tuiPrint(0, y, width, termbox.AttrBold, row.Name)
+			continue
+		}
+
+		attr := termbox.ColorDefault
+		if v.offset+i == v.selected {
+			attr |= termbox.AttrReverse
+		}
+
+		line := fmt.Sprintf(
+			"%-*s %10d %18s %7.2f%%",
+			nameWidth, truncateLeft(row.Name, nameWidth), row.Count, formatNumber(row.Value, fieldInfo.Unit), row.Percent,
+		)
+		tuiPrint(0, y, width, attr, line)
+	}
+
+	status := tuiHelp
+	if t.filtering {
+		status = "Filter: " + v.filter + "_"
+	} else if v.filter != "" {
+		status = fmt.Sprintf("Filter: %s  (/ to change)  %s", v.filter, tuiHelp)
+	}
+	tuiPrint(0, height-1, width, termbox.ColorDefault|termbox.AttrReverse, status)
+
+	termbox.Flush()
+}
+
+// tuiPrint writes s at x, y and fills the rest of the line up to width with
+// the same attributes, so that highlighted lines span the whole screen.
+func tuiPrint(x, y, width int, attr termbox.Attribute, s string) {
+	for _, r := range s {
+		if x >= width {
+			return
+		}
+
+		termbox.SetCell(x, y, r, attr, termbox.ColorDefault)
+		x++
+	}
+
+	for ; x < width; x++ {
+		termbox.SetCell(x, y, ' ', attr, termbox.ColorDefault)
+	}
+}
+
+// truncateLeft shortens s to width characters by cutting off its beginning,
+// which keeps the method name of long class names visible.
+func truncateLeft(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+
+	return "…" + string(runes[len(runes)-width+1:])
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTuiTestState(t *testing.T) *tuiState {
+	field = "excl_wt"
+
+	m := newReportTestMap()
+	s, err := newTuiState(m, m.Flatten(), "test.xhprof")
+	require.Nil(t, err)
+
+	return s
+}
+
+func TestTuiStateGetFunctionRows(t *testing.T) {
+	s := newTuiTestState(t)
+
+	// Exclusive wall times are compared with the wall time of main().
+	assert.Equal(t, []*tuiRow{
+		{Name: "main()", Count: 1, Value: 450, Percent: 45},
+		{Name: "bar", Count: 11, Value: 350, Percent: 35},
+		{Name: "foo", Count: 2, Value: 200, Percent: 20},
+	}, s.getFunctionRows(""))
+
+	assert.Equal(t, []*tuiRow{
+		{Name: "foo", Count: 2, Value: 200, Percent: 20},
+	}, s.getFunctionRows("FO"))
+
+	assert.Empty(t, s.getFunctionRows("baz"))
+}
+
+func TestTuiStateGetFamilyRows(t *testing.T) {
+	s := newTuiTestState(t)
+
+	// Parents and children are compared with the wall time of the function.
+	assert.Equal(t, []*tuiRow{
+		{Name: "Parents (2)", Title: true},
+		{Name: "foo", Count: 10, Value: 300, Percent: 300 * 100 / float32(350)},
+		{Name: "main()", Count: 1, Value: 50, Percent: 50 * 100 / float32(350)},
+		{Name: "Children (0)", Title: true},
+	}, s.getFamilyRows("bar", ""))
+
+	// The filter applies to the relatives, not to their counts in the titles.
+	assert.Equal(t, []*tuiRow{
+		{Name: "Parents (1)", Title: true},
+		{Name: "main()", Count: 2, Value: 500, Percent: 100},
+		{Name: "Children (1)", Title: true},
+	}, s.getFamilyRows("foo", "main"))
+}
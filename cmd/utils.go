@@ -2,14 +2,35 @@ package cmd
 
 import (
 	"fmt"
-	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/tideways/toolkit/xhprof"
+)
+
+// inputFormatUsage is the help of the --format flag of all commands reading
+// profiles.
+const inputFormatUsage = "Format of the input files (auto, xhprof, xhgui, callgrind, xdebug-trace, collapsed, serialized)"
+
+// aggregateUsage is the help of the --aggregate flag of all commands combining
+// multiple profiles.
+const aggregateUsage = "How multiple profiles are combined per function and call (mean, median, p90, p95, p99, max, min, sum)"
 
-	"github.com/olekukonko/tablewriter"
+// Usages of the --include, --exclude, --focus and --ignore flags of all
+// commands that display profiles.
+const (
+	includeUsage = "If provided, only functions matching this regex and main() are kept, with the costs of the others attributed to their nearest kept caller"
+	excludeUsage = "If provided, functions matching this regex are removed, with their costs attributed to their callers"
+	focusUsage   = "If provided, only the calls into functions matching this regex, the calls below them and their callers are kept"
+	ignoreUsage  = "If provided, functions matching this regex are removed along with the calls below them, with their costs attributed to their callers"
 )
 
+// groupByUsage is the help of the --group-by flag of all commands that can
+// roll functions up into groups.
+const groupByUsage = "If provided, functions are rolled up into groups by class, namespace, namespace-depth=N (the first N parts of the namespace), file, or regex=PATTERN (the first submatch of PATTERN)"
+
 type Unit struct {
 	Name    string
 	Divisor float32
@@ -115,76 +136,436 @@ var fieldsMap map[string]FieldInfo = map[string]FieldInfo{
 	},
 }
 
+// loadPairCallMaps reads the profiles of all paths in format. The parts of a
+// callgrind file are added up unless parts is "avg", in which case each of
+// them is returned as a profile of its own. The filters of --focus, --ignore,
+// --include and --exclude are applied to each profile.
+func loadPairCallMaps(paths []string, format, parts string) ([]*xhprof.PairCallMap, error) {
+	if parts != "sum" && parts != "avg" {
+		return nil, fmt.Errorf("Provided parts mode (%s) is not valid, use sum or avg", parts)
+	}
+
+	maps := make([]*xhprof.PairCallMap, 0, len(paths))
+	for _, path := range paths {
+		f := xhprof.NewFile(path, format)
+		if parts == "sum" {
+			m, err := f.GetPairCallMap()
+			if err != nil {
+				return nil, err
+			}
+
+			maps = append(maps, m)
+			continue
+		}
+
+		m, err := f.GetPairCallMaps()
+		if err != nil {
+			return nil, err
+		}
+
+		maps = append(maps, m...)
+	}
+
+	return filterMaps(maps)
+}
+
+// filterMaps applies --focus, --ignore, --include and --exclude to the maps,
+// in this order.
+func filterMaps(maps []*xhprof.PairCallMap) ([]*xhprof.PairCallMap, error) {
+	filters := []struct {
+		flag    string
+		pattern string
+		apply   func(*xhprof.PairCallMap, *regexp.Regexp) *xhprof.PairCallMap
+	}{
+		{"focus", focusPattern, (*xhprof.PairCallMap).Focus},
+		{"ignore", ignorePattern, (*xhprof.PairCallMap).Ignore},
+		{"include", includePattern, (*xhprof.PairCallMap).Include},
+		{"exclude", excludePattern, (*xhprof.PairCallMap).Exclude},
+	}
+
+	for _, f := range filters {
+		if f.pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(f.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Provided --%s regex is not valid: %s", f.flag, err)
+		}
+
+		for i, m := range maps {
+			maps[i] = f.apply(m, re)
+		}
+	}
+
+	return maps, nil
+}
+
+// aggregateMaps combines the maps of multiple files, runs or parts with mode,
+// into a map with the aggregate of each pair call and a profile with the
+// aggregate of each function.
+func aggregateMaps(maps []*xhprof.PairCallMap, mode string) (*xhprof.PairCallMap, *xhprof.Profile, error) {
+	m, err := xhprof.AggregatePairCallMaps(maps, mode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Provided aggregation (%s) is not valid, use %s", mode, strings.Join(xhprof.Aggregations, ", "))
+	}
+
+	// The means and sums of the calls of a function add up to the mean and
+	// sum of the function, percentiles have to be computed per function.
+	if mode == "mean" || mode == "sum" || len(maps) == 1 {
+		return m, m.Flatten(), nil
+	}
+
+	profiles := make([]*xhprof.Profile, 0, len(maps))
+	for _, pm := range maps {
+		profiles = append(profiles, pm.Flatten())
+	}
+
+	profile, err := xhprof.AggregateProfiles(profiles, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, profile, nil
+}
+
+// groupMaps rolls the functions of the maps up into the groups of groupBy, or
+// returns the maps as they are if groupBy is empty.
+func groupMaps(maps []*xhprof.PairCallMap, groupBy string) ([]*xhprof.PairCallMap, error) {
+	var group xhprof.GroupFunc
+	switch {
+	case groupBy == "":
+		return maps, nil
+	case groupBy == "class":
+		group = xhprof.GroupByClass
+	case groupBy == "namespace":
+		group = xhprof.GroupByNamespace(0)
+	case groupBy == "file":
+	case strings.HasPrefix(groupBy, "namespace-depth="):
+		depth, err := strconv.Atoi(strings.TrimPrefix(groupBy, "namespace-depth="))
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("Provided namespace depth (%s) is not valid, use a number of at least 1", strings.TrimPrefix(groupBy, "namespace-depth="))
+		}
+
+		group = xhprof.GroupByNamespace(depth)
+	case strings.HasPrefix(groupBy, "regex="):
+		re, err := regexp.Compile(strings.TrimPrefix(groupBy, "regex="))
+		if err != nil {
+			return nil, fmt.Errorf("Provided grouping regex is not valid: %s", err)
+		}
+
+		group = xhprof.GroupByRegexp(re)
+	default:
+		return nil, fmt.Errorf("Provided grouping (%s) is not valid, use class, namespace, namespace-depth=N, file or regex=PATTERN", groupBy)
+	}
+
+	grouped := make([]*xhprof.PairCallMap, 0, len(maps))
+	for _, m := range maps {
+		if groupBy == "file" {
+			group = m.GroupByFile()
+		}
+
+		grouped = append(grouped, m.Group(group))
+	}
+
+	return grouped, nil
+}
+
+// describeRun returns the request a profile was recorded for, like
+// "GET /index.php (2018-06-11T10:00:00Z)" for runs of XHGui, or "" if the
+// profile has no such meta data.
+func describeRun(m *xhprof.PairCallMap) string {
+	url := m.Meta["url"]
+	if url == "" {
+		return ""
+	}
+
+	if method := m.Meta["SERVER.REQUEST_METHOD"]; method != "" {
+		url = method + " " + url
+	}
+
+	if date := m.Meta["request_ts"]; date != "" {
+		url = fmt.Sprintf("%s (%s)", url, date)
+	} else if date := m.Meta["request_date"]; date != "" {
+		url = fmt.Sprintf("%s (%s)", url, date)
+	}
+
+	return url
+}
+
+// writeOutFile writes data to path, compressed with gzip if path ends in .gz.
+func writeOutFile(path string, data []byte) error {
+	fh, err := xhprof.CreateFile(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fh.Write(data); err != nil {
+		fh.Close()
+		return err
+	}
+
+	return fh.Close()
+}
+
+// getFieldInfo returns the FieldInfo for a dimension, which is either a key of
+// fieldsMap or an event of a callgrind profile like Ir or excl_Ir.
+func getFieldInfo(field string, profile *xhprof.Profile) (FieldInfo, bool) {
+	if fieldInfo, ok := fieldsMap[field]; ok {
+		return fieldInfo, true
+	}
+
+	event := strings.TrimPrefix(field, "excl_")
+	if profile.Main == nil {
+		return FieldInfo{}, false
+	}
+
+	if _, ok := profile.Main.Costs[event]; !ok {
+		return FieldInfo{}, false
+	}
+
+	return getEventFieldInfo(event, strings.HasPrefix(field, "excl_")), true
+}
+
+func getEventFieldInfo(event string, exclusive bool) FieldInfo {
+	if exclusive {
+		return FieldInfo{
+			Name:   "ExclusiveCosts." + event,
+			Label:  "Exclusive " + event,
+			Header: event,
+			Unit:   plain,
+		}
+	}
+
+	return FieldInfo{
+		Name:   "Costs." + event,
+		Label:  "Inclusive " + event,
+		Header: event,
+		Unit:   plain,
+	}
+}
+
+// dimensions are the keys of fieldsMap in the order they are offered for
+// sorting, e.g. as columns of the function table of reports.
+var dimensions = []string{
+	"wt", "excl_wt", "cpu", "excl_cpu", "io", "excl_io", "memory", "excl_memory",
+	"num_alloc", "excl_num_alloc", "num_free", "excl_num_free", "alloc_amt", "excl_alloc_amt",
+}
+
+// getDimensions returns the dimensions the profile has data for, followed by
+// the inclusive and exclusive events of callgrind profiles.
+func getDimensions(profile *xhprof.Profile) []FieldInfo {
+	if profile.Main == nil {
+		return nil
+	}
+
+	res := make([]FieldInfo, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		info := fieldsMap[dimension]
+		if strings.HasPrefix(dimension, "excl_") {
+			info = fieldsMap[strings.TrimPrefix(dimension, "excl_")]
+		}
+
+		// I/O time is wall time without CPU time, which only makes sense
+		// when there is CPU time.
+		if strings.HasSuffix(dimension, "io") && profile.Main.CpuTime == 0 {
+			continue
+		}
+
+		if profile.Main.GetFloat32Field(info.Name) == 0 {
+			continue
+		}
+
+		res = append(res, fieldsMap[dimension])
+	}
+
+	events := make([]string, 0, len(profile.Main.Costs))
+	for event := range profile.Main.Costs {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	for _, event := range events {
+		res = append(res, getEventFieldInfo(event, false), getEventFieldInfo(event, true))
+	}
+
+	return res
+}
+
+// jsonProfile is the JSON output of analyze, with the threshold in the unit of
+// the calls' metrics.
+type jsonProfile struct {
+	Dimension string      `json:"dimension"`
+	Label     string      `json:"label"`
+	Threshold float32     `json:"threshold"`
+	Calls     []*jsonCall `json:"calls"`
+}
+
 func renderProfile(profile *xhprof.Profile, field string, fieldInfo FieldInfo, minValue float32) error {
-	header := fieldInfo.Header
-	exclHeader := "Excl. " + fieldInfo.Header
+	if output == "json" {
+		return renderJSON(&jsonProfile{
+			Dimension: field,
+			Label:     fieldInfo.Label,
+			Threshold: minValue,
+			Calls:     newJSONCalls(profile.Calls),
+		})
+	}
+
+	headers, rows := getProfileTable(profile, field, fieldInfo, minValue, hasLocation(profile))
+
+	printInfo("Showing XHProf data by %s\n", fieldInfo.Label)
+
+	return renderTable(headers, rows)
+}
+
+// hasLocation returns whether any call of the profiles has a source file.
+func hasLocation(profiles ...*xhprof.Profile) bool {
+	for _, profile := range profiles {
+		for _, call := range profile.Calls {
+			if call.File != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func getProfileTable(profile *xhprof.Profile, field string, fieldInfo FieldInfo, minValue float32, withLocation bool) ([]string, [][]string) {
+	header := formatHeader(fieldInfo.Header, fieldInfo.Unit)
+	exclHeader := formatHeader("Excl. "+fieldInfo.Header, fieldInfo.Unit)
 	var fields []FieldInfo
 	var headers []string
 	if strings.HasPrefix(field, "excl_") {
-		fields = []FieldInfo{fieldsMap[strings.TrimPrefix(field, "excl_")], fieldInfo}
-		exclHeader = fmt.Sprintf("%s (>= %2.2f %s)", exclHeader, minValue/fieldInfo.Unit.Divisor, fieldInfo.Unit.Name)
+		inclFieldInfo, ok := fieldsMap[strings.TrimPrefix(field, "excl_")]
+		if !ok {
+			inclFieldInfo = getEventFieldInfo(strings.TrimPrefix(field, "excl_"), false)
+		}
+
+		fields = []FieldInfo{inclFieldInfo, fieldInfo}
+		if !isMachineOutput() {
+			exclHeader = fmt.Sprintf("%s (>= %2.2f %s)", exclHeader, minValue/fieldInfo.Unit.Divisor, fieldInfo.Unit.Name)
+		}
 		headers = []string{"Function", "Count", header, exclHeader}
 	} else {
 		fields = []FieldInfo{fieldInfo}
-		header = fmt.Sprintf("%s (>= %2.2f %s)", header, minValue/fieldInfo.Unit.Divisor, fieldInfo.Unit.Name)
+		if !isMachineOutput() {
+			header = fmt.Sprintf("%s (>= %2.2f %s)", header, minValue/fieldInfo.Unit.Divisor, fieldInfo.Unit.Name)
+		}
 		headers = []string{"Function", "Count", header}
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader(headers)
-	for _, call := range profile.Calls {
-		table.Append(getRow(call, fields))
+	if withLocation && isMachineOutput() {
+		headers = append([]string{headers[0], "File", "Line"}, headers[1:]...)
+	} else if withLocation {
+		headers = append([]string{headers[0], "Location"}, headers[1:]...)
 	}
 
-	fmt.Printf("Showing XHProf data by %s\n", fieldInfo.Label)
-	table.Render()
+	rows := make([][]string, 0, len(profile.Calls))
+	for _, call := range profile.Calls {
+		row := getRow(call, fields)
+		if withLocation && isMachineOutput() {
+			row = append([]string{row[0], call.File, fmt.Sprintf("%d", call.Line)}, row[1:]...)
+		} else if withLocation {
+			row = append([]string{row[0], formatLocation(call.File, call.Line)}, row[1:]...)
+		}
+
+		rows = append(rows, row)
+	}
 
-	return nil
+	return headers, rows
 }
 
 func getRow(call *xhprof.Call, fields []FieldInfo) []string {
+	name := call.Name
+	if !isMachineOutput() {
+		name = fmt.Sprintf("%.90s", name)
+	}
+
 	res := []string{
-		fmt.Sprintf("%.90s", call.Name),
+		name,
 		fmt.Sprintf("%d", call.Count),
 	}
 
 	for _, field := range fields {
-		var col string
-		if field.Unit == plain {
-			col = fmt.Sprintf("%2.0f %s", call.GetFloat32Field(field.Name)/field.Unit.Divisor, field.Unit.Name)
-		} else {
-			col = fmt.Sprintf("%2.2f %s", call.GetFloat32Field(field.Name)/field.Unit.Divisor, field.Unit.Name)
-		}
-
-		res = append(res, col)
+		res = append(res, formatValue(call.GetFloat32Field(field.Name), field.Unit))
 	}
 
 	return res
 }
 
+// formatLocation returns file:line, shortening long paths from the left.
+func formatLocation(file string, line int) string {
+	if file == "" {
+		return ""
+	}
+
+	if len(file) > 50 {
+		file = "..." + file[len(file)-47:]
+	}
+
+	if line <= 0 {
+		return file
+	}
+
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// jsonProfileDiff is the JSON output of compare.
+type jsonProfileDiff struct {
+	Limit int             `json:"limit"`
+	Calls []*jsonCallDiff `json:"calls"`
+}
+
+type jsonCallDiff struct {
+	Name           string  `json:"name"`
+	Count          int     `json:"count"`
+	WallTime       float32 `json:"wt"`
+	FractionWtFrom float32 `json:"fraction_wt_from"`
+	FractionWtTo   float32 `json:"fraction_wt_to"`
+}
+
 func renderProfileDiff(diff *xhprof.ProfileDiff, limit int) error {
 	diff.Sort()
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Function", "Count", "Wall-Time", "Fraction Wall-Time From", "Fraction Wall-Time To"})
-	for i, call := range diff.Calls {
-		if i >= limit {
-			break
+	calls := diff.Calls
+	if len(calls) > limit {
+		calls = calls[:limit]
+	}
+
+	if output == "json" {
+		res := &jsonProfileDiff{Limit: limit, Calls: make([]*jsonCallDiff, 0, len(calls))}
+		for _, call := range calls {
+			res.Calls = append(res.Calls, &jsonCallDiff{
+				Name:           call.Name,
+				Count:          call.Count,
+				WallTime:       call.WallTime,
+				FractionWtFrom: call.FractionWtFrom,
+				FractionWtTo:   call.FractionWtTo,
+			})
+		}
+
+		return renderJSON(res)
+	}
+
+	headers := []string{"Function", "Count", formatHeader("Wall-Time", ms), "Fraction Wall-Time From", "Fraction Wall-Time To"}
+	rows := make([][]string, 0, len(calls))
+	for _, call := range calls {
+		name := call.Name
+		if !isMachineOutput() {
+			name = fmt.Sprintf("%.90s", name)
 		}
 
-		row := []string{
-			fmt.Sprintf("%.90s", call.Name),
+		rows = append(rows, []string{
+			name,
 			fmt.Sprintf("%d", call.Count),
-			fmt.Sprintf("%2.2f ms", call.WallTime/1000),
+			formatValue(call.WallTime, ms),
 			fmt.Sprintf("%2.2f", call.FractionWtFrom),
 			fmt.Sprintf("%2.2f", call.FractionWtTo),
-		}
-
-		table.Append(row)
+		})
 	}
 
-	fmt.Printf("Showing XHProf data by the difference of fractions\n")
-	table.Render()
+	printInfo("Showing XHProf data by the difference of fractions\n")
 
-	return nil
+	return renderTable(headers, rows)
 }
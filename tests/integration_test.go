@@ -70,6 +70,8 @@ func TestParseWPIndexCallgrind(t *testing.T) {
 				ExclusiveWallTime: 54,
 				IoTime:            305039,
 				ExclusiveIoTime:   54,
+				File:              "/var/www/wordpress/index.php",
+				Line:              1,
 			},
 			&xhprof.Call{
 				Name:              "require::/var/www/wordpress/wp-blog-header.php",
@@ -78,6 +80,8 @@ func TestParseWPIndexCallgrind(t *testing.T) {
 				ExclusiveWallTime: 86,
 				IoTime:            304981,
 				ExclusiveIoTime:   86,
+				File:              "/var/www/wordpress/wp-blog-header.php",
+				Line:              1,
 			},
 		},
 	}
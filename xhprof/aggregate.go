@@ -0,0 +1,291 @@
+package xhprof
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Aggregations are the modes of AggregatePairCallMaps and AggregateProfiles.
+var Aggregations = []string{"mean", "median", "p90", "p95", "p99", "max", "min", "sum"}
+
+// aggregateFunc combines the values of a field in all profiles into one.
+type aggregateFunc func(values []float64) float64
+
+func getAggregateFunc(mode string) (aggregateFunc, error) {
+	switch mode {
+	case "mean":
+		return func(values []float64) float64 {
+			return sumValues(values) / float64(len(values))
+		}, nil
+	case "sum":
+		return sumValues, nil
+	case "median":
+		return percentileFunc(50), nil
+	case "p90":
+		return percentileFunc(90), nil
+	case "p95":
+		return percentileFunc(95), nil
+	case "p99":
+		return percentileFunc(99), nil
+	case "min":
+		return percentileFunc(0), nil
+	case "max":
+		return percentileFunc(100), nil
+	}
+
+	return nil, errors.New("Unsupported aggregation: " + mode)
+}
+
+func sumValues(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum
+}
+
+// percentileFunc returns the p-th percentile of the values, interpolated
+// linearly between the two nearest values.
+func percentileFunc(p float64) aggregateFunc {
+	return func(values []float64) float64 {
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+
+		pos := p / 100 * float64(len(sorted)-1)
+		i := int(pos)
+		if i+1 >= len(sorted) {
+			return sorted[len(sorted)-1]
+		}
+
+		return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+	}
+}
+
+// AggregatePairCallMaps combines the maps into one with mode, which is one of
+// Aggregations, for each pair call separately. A pair call missing from a map
+// counts as 0 for it, and each field is aggregated on its own, so the p95 of
+// the wall time and of the memory of a pair call may stem from different
+// profiles.
+func AggregatePairCallMaps(maps []*PairCallMap, mode string) (*PairCallMap, error) {
+	agg, err := getAggregateFunc(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(maps) == 1:
+		return maps[0], nil
+	case mode == "mean":
+		return AvgPairCallMaps(maps), nil
+	case mode == "sum":
+		return SumPairCallMaps(maps), nil
+	}
+
+	res := NewPairCallMap()
+
+	names := make(map[string]bool)
+	fns := make(map[string]bool)
+	for _, m := range maps {
+		for name := range m.M {
+			names[name] = true
+		}
+		for fn := range m.Sources {
+			fns[fn] = true
+		}
+	}
+
+	pairCalls := make([]interface{}, len(maps))
+	for name := range names {
+		for i, m := range maps {
+			pairCalls[i] = m.M[name]
+		}
+
+		res.M[name] = new(PairCall)
+		aggregateFields(res.M[name], pairCalls, agg)
+	}
+
+	sources := make([]*Source, len(maps))
+	for fn := range fns {
+		for i, m := range maps {
+			sources[i] = m.Sources[fn]
+		}
+
+		res.NewSource(fn).aggregate(sources, agg)
+	}
+
+	return res, nil
+}
+
+// AggregateProfiles combines the profiles into one with mode, which is one of
+// Aggregations, for each function separately, like AggregatePairCallMaps does
+// for each pair call.
+func AggregateProfiles(profiles []*Profile, mode string) (*Profile, error) {
+	agg, err := getAggregateFunc(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(profiles) == 1 {
+		return profiles[0], nil
+	}
+
+	names := make(map[string]bool)
+	callMaps := make([]map[string]*Call, len(profiles))
+	for i, p := range profiles {
+		callMaps[i] = make(map[string]*Call, len(p.Calls))
+		for _, c := range p.Calls {
+			callMaps[i][c.Name] = c
+			names[c.Name] = true
+		}
+	}
+
+	res := new(Profile)
+	calls := make([]interface{}, len(profiles))
+	for name := range names {
+		call := &Call{Name: name}
+		for i, m := range callMaps {
+			calls[i] = m[name]
+			if c := m[name]; c != nil && call.File == "" {
+				call.File, call.Line = c.File, c.Line
+			}
+		}
+
+		aggregateFields(call, calls, agg)
+		if name == "main()" {
+			res.Main = call
+		}
+
+		res.Calls = append(res.Calls, call)
+	}
+
+	return res, nil
+}
+
+// aggregate sets the costs of each line and the calls of each call site of s
+// to their aggregate in sources, of which some may be nil.
+func (s *Source) aggregate(sources []*Source, agg aggregateFunc) {
+	type callSiteKey struct {
+		function, file string
+		line           int
+	}
+
+	lines := make([]interface{}, len(sources))
+	counts := make(map[callSiteKey][]float64)
+	for i, src := range sources {
+		if src == nil {
+			continue
+		}
+
+		if s.File == "" {
+			s.File, s.Line = src.File, src.Line
+		}
+
+		for line := range src.Lines {
+			s.Lines[line] = nil
+		}
+
+		for _, c := range src.CallSites {
+			key := callSiteKey{c.Function, c.File, c.Line}
+			if _, ok := counts[key]; !ok {
+				counts[key] = make([]float64, len(sources))
+			}
+
+			counts[key][i] += float64(c.Count)
+		}
+	}
+
+	for line := range s.Lines {
+		for i, src := range sources {
+			lines[i] = nil
+			if src != nil {
+				if pc, ok := src.Lines[line]; ok {
+					lines[i] = pc
+				}
+			}
+		}
+
+		s.Lines[line] = new(PairCall)
+		aggregateFields(s.Lines[line], lines, agg)
+	}
+
+	for key, values := range counts {
+		s.AddCallSite(key.function, key.file, key.line, int(math.Round(agg(values))))
+	}
+}
+
+// aggregateFields sets each numeric field and each value of the cost maps of
+// dst, a pointer to a PairCall or Call, to the aggregate of the same field of
+// srcs. Sources that are nil count as 0, and the Line of calls is left as it
+// is.
+func aggregateFields(dst interface{}, srcs []interface{}, agg aggregateFunc) {
+	dv := reflect.ValueOf(dst).Elem()
+	dt := dv.Type()
+
+	svs := make([]reflect.Value, len(srcs))
+	for i, src := range srcs {
+		if sv := reflect.ValueOf(src); sv.IsValid() && !sv.IsNil() {
+			svs[i] = sv.Elem()
+		}
+	}
+
+	values := make([]float64, len(srcs))
+	for f := 0; f < dt.NumField(); f++ {
+		field := dv.Field(f)
+		if !field.CanSet() || dt.Field(f).Name == "Line" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.Float32:
+			for i, sv := range svs {
+				values[i] = 0
+				if sv.IsValid() {
+					values[i] = sv.Field(f).Float()
+				}
+			}
+
+			field.SetFloat(agg(values))
+		case reflect.Int:
+			for i, sv := range svs {
+				values[i] = 0
+				if sv.IsValid() {
+					values[i] = float64(sv.Field(f).Int())
+				}
+			}
+
+			field.SetInt(int64(math.Round(agg(values))))
+		case reflect.Map:
+			keys := make(map[string]bool)
+			for _, sv := range svs {
+				if sv.IsValid() {
+					for _, k := range sv.Field(f).MapKeys() {
+						keys[k.String()] = true
+					}
+				}
+			}
+
+			if len(keys) == 0 {
+				continue
+			}
+
+			costs := make(map[string]float32, len(keys))
+			for k := range keys {
+				for i, sv := range svs {
+					values[i] = 0
+					if sv.IsValid() {
+						if v := sv.Field(f).MapIndex(reflect.ValueOf(k)); v.IsValid() {
+							values[i] = v.Float()
+						}
+					}
+				}
+
+				costs[k] = float32(agg(values))
+			}
+
+			field.Set(reflect.ValueOf(costs))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package xhprof
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentileFunc(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3}
+
+	assert.Equal(t, 1.0, percentileFunc(0)(values))
+	assert.Equal(t, 3.0, percentileFunc(50)(values))
+	assert.Equal(t, 4.6, percentileFunc(90)(values))
+	assert.Equal(t, 5.0, percentileFunc(100)(values))
+	assert.Equal(t, 2.5, percentileFunc(50)([]float64{4, 1, 2, 3}))
+	assert.Equal(t, 7.0, percentileFunc(95)([]float64{7}))
+
+	// The values are not sorted in place.
+	assert.Equal(t, []float64{5, 1, 4, 2, 3}, values)
+}
+
+func TestAggregatePairCallMaps(t *testing.T) {
+	maps := make([]*PairCallMap, 0, 4)
+	for i, wt := range []float32{100, 400, 200, 300} {
+		m := NewPairCallMap()
+		m.M["main()"] = &PairCall{Count: 1, WallTime: wt + 50, Costs: map[string]float32{"Ir": wt}}
+		m.M["main()==>foo"] = &PairCall{Count: i + 1, WallTime: wt}
+		if i == 1 {
+			m.M["main()==>bar"] = &PairCall{Count: 1, WallTime: 1000}
+		}
+
+		src := m.NewSource("main()")
+		src.File, src.Line = "index.php", 1
+		src.AddLineCosts(3, &PairCall{WallTime: 50})
+		src.AddCallSite("foo", "index.php", 3, i+1)
+
+		maps = append(maps, m)
+	}
+
+	m, err := AggregatePairCallMaps(maps, "max")
+	require.NoError(t, err)
+	assert.Equal(t, &PairCall{Count: 1, WallTime: 450, Costs: map[string]float32{"Ir": 400}}, m.M["main()"])
+	assert.Equal(t, &PairCall{Count: 4, WallTime: 400}, m.M["main()==>foo"])
+	assert.Equal(t, &PairCall{Count: 1, WallTime: 1000}, m.M["main()==>bar"])
+
+	m, err = AggregatePairCallMaps(maps, "median")
+	require.NoError(t, err)
+	assert.Equal(t, &PairCall{Count: 1, WallTime: 300, Costs: map[string]float32{"Ir": 250}}, m.M["main()"])
+	assert.Equal(t, &PairCall{Count: 3, WallTime: 250}, m.M["main()==>foo"])
+	assert.Equal(t, &PairCall{Count: 0, WallTime: 0}, m.M["main()==>bar"])
+
+	src := m.Sources["main()"]
+	require.NotNil(t, src)
+	assert.Equal(t, "index.php", src.File)
+	assert.Equal(t, 1, src.Line)
+	assert.Equal(t, &PairCall{WallTime: 50}, src.Lines[3])
+	assert.Equal(t, []*CallSite{&CallSite{Function: "foo", File: "index.php", Line: 3, Count: 3}}, src.CallSites)
+
+	m, err = AggregatePairCallMaps(maps, "mean")
+	require.NoError(t, err)
+	assert.Equal(t, float32(250), m.M["main()==>foo"].WallTime)
+
+	m, err = AggregatePairCallMaps(maps[:1], "p95")
+	require.NoError(t, err)
+	assert.Equal(t, maps[0], m)
+
+	_, err = AggregatePairCallMaps(maps, "p50")
+	assert.EqualError(t, err, "Unsupported aggregation: p50")
+}
+
+func TestAggregateProfiles(t *testing.T) {
+	profiles := make([]*Profile, 0, 3)
+	for _, wt := range []float32{100, 300, 200} {
+		main := &Call{Name: "main()", Count: 1, WallTime: wt, ExclusiveWallTime: wt / 2}
+		profiles = append(profiles, &Profile{
+			Main:  main,
+			Calls: []*Call{main, &Call{Name: "foo", Count: 2, WallTime: wt / 2, File: "foo.php", Line: 7}},
+		})
+	}
+
+	p, err := AggregateProfiles(profiles, "p90")
+	require.NoError(t, err)
+	require.Len(t, p.Calls, 2)
+	assert.Equal(t, &Call{Name: "main()", Count: 1, WallTime: 280, ExclusiveWallTime: 140}, p.Main)
+	assert.Equal(t, &Call{Name: "foo", Count: 2, WallTime: 140, File: "foo.php", Line: 7}, p.GetCall("foo"))
+
+	p, err = AggregateProfiles(profiles, "sum")
+	require.NoError(t, err)
+	assert.Equal(t, float32(600), p.Main.WallTime)
+	assert.Equal(t, 3, p.Main.Count)
+}
@@ -0,0 +1,155 @@
+package xhprof
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Budget holds the performance rules that profiles have to satisfy, and the
+// baseline profiles that rules limiting an increase are checked against.
+type Budget struct {
+	Baseline []string      `yaml:"baseline"`
+	Rules    []*BudgetRule `yaml:"rules"`
+}
+
+// BudgetRule limits a dimension of a function, like "main() wt <= 120ms", or
+// its increase compared to the baseline, like "main() wt increase <= 10%".
+type BudgetRule struct {
+	Rule      string
+	Function  string
+	Dimension string
+	Increase  bool
+	Strict    bool
+
+	// Limit is in microseconds for time units, in bytes for memory units
+	// and in percent for "%". Without unit, it is as written and has to be
+	// converted into the unit of the dimension before calling Check.
+	Limit float32
+	Unit  string
+}
+
+// Units of the limits of budget rules.
+const (
+	BudgetTime    = "time"
+	BudgetMemory  = "memory"
+	BudgetPercent = "%"
+)
+
+var budgetRulePattern = regexp.MustCompile(`^(.+?)\s+(\S+?)(\s+increase)?\s*(<=|<)\s*([0-9.]+)\s*([A-Za-zµ%]*)$`)
+
+var budgetUnits = map[string]struct {
+	unit   string
+	factor float32
+}{
+	"us": {BudgetTime, 1},
+	"µs": {BudgetTime, 1},
+	"ms": {BudgetTime, 1000},
+	"s":  {BudgetTime, 1000000},
+	"b":  {BudgetMemory, 1},
+	"kb": {BudgetMemory, 1024},
+	"mb": {BudgetMemory, 1024 * 1024},
+	"gb": {BudgetMemory, 1024 * 1024 * 1024},
+	"%":  {BudgetPercent, 1},
+}
+
+// ParseBudget parses a budget in YAML, with one rule per string.
+func ParseBudget(rd io.Reader) (*Budget, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Budget)
+	if err = yaml.UnmarshalStrict(data, b); err != nil {
+		return nil, err
+	}
+
+	if len(b.Rules) == 0 {
+		return nil, fmt.Errorf("Budget has no rules")
+	}
+
+	return b, nil
+}
+
+// ParseBudgetRule parses a rule like "main() wt <= 120ms".
+func ParseBudgetRule(s string) (*BudgetRule, error) {
+	s = strings.TrimSpace(s)
+	match := budgetRulePattern.FindStringSubmatch(s)
+	if match == nil {
+		return nil, fmt.Errorf("Invalid budget rule %q, use \"function dimension <= limit\" or \"function dimension increase <= limit\"", s)
+	}
+
+	r := &BudgetRule{
+		Rule:      s,
+		Function:  match[1],
+		Dimension: match[2],
+		Increase:  match[3] != "",
+		Strict:    match[4] == "<",
+	}
+
+	limit, err := strconv.ParseFloat(match[5], 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid limit of budget rule %q: %s", s, err)
+	}
+	r.Limit = float32(limit)
+
+	if match[6] != "" {
+		unit, ok := budgetUnits[strings.ToLower(match[6])]
+		if !ok {
+			return nil, fmt.Errorf("Invalid unit %s of budget rule %q, use us, ms, s, B, KB, MB, GB or %%", match[6], s)
+		}
+
+		r.Limit *= unit.factor
+		r.Unit = unit.unit
+	}
+
+	if r.Unit == BudgetPercent && !r.Increase {
+		return nil, fmt.Errorf("Invalid budget rule %q, only increases can be limited in percent", s)
+	}
+
+	return r, nil
+}
+
+func (r *BudgetRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseBudgetRule(s)
+	if err != nil {
+		return err
+	}
+
+	*r = *parsed
+
+	return nil
+}
+
+// Check returns whether value satisfies the rule, with baseline being the
+// value of the baseline for increases. A function that is new exceeds any
+// increase in percent.
+func (r *BudgetRule) Check(value, baseline float32) bool {
+	if r.Increase {
+		value -= baseline
+		if r.Unit == BudgetPercent {
+			if baseline == 0 {
+				return value <= 0
+			}
+
+			value = 100 * value / baseline
+		}
+	}
+
+	if r.Strict {
+		return value < r.Limit
+	}
+
+	return value <= r.Limit
+}
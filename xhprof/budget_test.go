@@ -0,0 +1,93 @@
+package xhprof
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBudgetRule(t *testing.T) {
+	r, err := ParseBudgetRule("main() wt <= 120ms")
+	require.NoError(t, err)
+	assert.Equal(t, &BudgetRule{Rule: "main() wt <= 120ms", Function: "main()", Dimension: "wt", Limit: 120000, Unit: BudgetTime}, r)
+
+	r, err = ParseBudgetRule("  mysqli_query count < 30 ")
+	require.NoError(t, err)
+	assert.Equal(t, &BudgetRule{Rule: "mysqli_query count < 30", Function: "mysqli_query", Dimension: "count", Strict: true, Limit: 30}, r)
+
+	r, err = ParseBudgetRule("Foo::bar excl_memory <= 2MB")
+	require.NoError(t, err)
+	assert.Equal(t, "Foo::bar", r.Function)
+	assert.Equal(t, float32(2*1024*1024), r.Limit)
+	assert.Equal(t, BudgetMemory, r.Unit)
+
+	r, err = ParseBudgetRule("{closure} wt increase <= 10%")
+	require.NoError(t, err)
+	assert.Equal(t, "{closure}", r.Function)
+	assert.True(t, r.Increase)
+	assert.Equal(t, float32(10), r.Limit)
+	assert.Equal(t, BudgetPercent, r.Unit)
+
+	_, err = ParseBudgetRule("main() wt >= 10ms")
+	assert.Error(t, err)
+
+	_, err = ParseBudgetRule("main() wt <= 10 parsecs")
+	assert.Error(t, err)
+
+	_, err = ParseBudgetRule("main() wt <= 10h")
+	assert.EqualError(t, err, `Invalid unit h of budget rule "main() wt <= 10h", use us, ms, s, B, KB, MB, GB or %`)
+
+	_, err = ParseBudgetRule("main() wt <= 10%")
+	assert.EqualError(t, err, `Invalid budget rule "main() wt <= 10%", only increases can be limited in percent`)
+}
+
+func TestParseBudget(t *testing.T) {
+	b, err := ParseBudget(strings.NewReader(`
+baseline:
+  - baseline/*.xhprof
+rules:
+  - main() wt <= 120ms
+  - main() wt increase <= 10%
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baseline/*.xhprof"}, b.Baseline)
+	require.Len(t, b.Rules, 2)
+	assert.Equal(t, "main() wt <= 120ms", b.Rules[0].Rule)
+	assert.True(t, b.Rules[1].Increase)
+
+	_, err = ParseBudget(strings.NewReader("rules:\n  - main() wt\n"))
+	assert.Error(t, err)
+
+	_, err = ParseBudget(strings.NewReader("limits:\n  - main() wt <= 1ms\n"))
+	assert.Error(t, err)
+
+	_, err = ParseBudget(strings.NewReader("baseline: []\n"))
+	assert.EqualError(t, err, "Budget has no rules")
+}
+
+func TestBudgetRuleCheck(t *testing.T) {
+	r, err := ParseBudgetRule("main() wt <= 120ms")
+	require.NoError(t, err)
+	assert.True(t, r.Check(120000, 0))
+	assert.False(t, r.Check(120001, 0))
+
+	r, err = ParseBudgetRule("main() count < 3")
+	require.NoError(t, err)
+	assert.True(t, r.Check(2, 0))
+	assert.False(t, r.Check(3, 0))
+
+	r, err = ParseBudgetRule("main() wt increase <= 10%")
+	require.NoError(t, err)
+	assert.True(t, r.Check(110, 100))
+	assert.False(t, r.Check(111, 100))
+	assert.True(t, r.Check(50, 100))
+	assert.False(t, r.Check(1, 0))
+	assert.True(t, r.Check(0, 0))
+
+	r, err = ParseBudgetRule("main() wt increase <= 5ms")
+	require.NoError(t, err)
+	assert.True(t, r.Check(15000, 10000))
+	assert.False(t, r.Check(15001, 10000))
+}
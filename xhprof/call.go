@@ -2,8 +2,13 @@ package xhprof
 
 import (
 	"reflect"
+	"strings"
 )
 
+// exclusiveCostsPrefix selects a value of the ExclusiveCosts map in
+// GetFloat32Field, e.g. "ExclusiveCosts.Ir".
+const exclusiveCostsPrefix = "ExclusiveCosts."
+
 type Call struct {
 	Name                 string
 	Count                int
@@ -22,13 +27,27 @@ type Call struct {
 	ExclusiveNumFree     float32
 	AllocAmount          float32
 	ExclusiveAllocAmount float32
+	Costs                map[string]float32
+	ExclusiveCosts       map[string]float32
+	File                 string
+	Line                 int
 
 	graphvizId int
 }
 
 func (c *Call) GetFloat32Field(field string) float32 {
-	cVal := reflect.Indirect(reflect.ValueOf(c))
-	return float32(cVal.FieldByName(field).Float())
+	if strings.HasPrefix(field, costsPrefix) {
+		return c.Costs[strings.TrimPrefix(field, costsPrefix)]
+	} else if strings.HasPrefix(field, exclusiveCostsPrefix) {
+		return c.ExclusiveCosts[strings.TrimPrefix(field, exclusiveCostsPrefix)]
+	}
+
+	cVal := reflect.Indirect(reflect.ValueOf(c)).FieldByName(field)
+	if cVal.Kind() == reflect.Int {
+		return float32(cVal.Int())
+	}
+
+	return float32(cVal.Float())
 }
 
 func (c *Call) Add(o *Call) *Call {
@@ -48,6 +67,8 @@ func (c *Call) Add(o *Call) *Call {
 	c.ExclusiveNumAlloc += o.ExclusiveNumAlloc
 	c.ExclusiveNumFree += o.ExclusiveNumFree
 	c.ExclusiveAllocAmount += o.ExclusiveAllocAmount
+	c.Costs = addCosts(c.Costs, o.Costs, 1)
+	c.ExclusiveCosts = addCosts(c.ExclusiveCosts, o.ExclusiveCosts, 1)
 
 	return c
 }
@@ -76,6 +97,8 @@ func (c *Call) AddPairCall(p *PairCall) *Call {
 	c.ExclusiveNumAlloc += p.NumAlloc
 	c.ExclusiveNumFree += p.NumFree
 	c.ExclusiveAllocAmount += p.AllocAmount
+	c.Costs = addCosts(c.Costs, p.Costs, 1)
+	c.ExclusiveCosts = addCosts(c.ExclusiveCosts, p.Costs, 1)
 	return c
 }
 
@@ -87,6 +110,7 @@ func (c *Call) SubtractExcl(p *PairCall) *Call {
 	c.ExclusiveNumAlloc -= p.NumAlloc
 	c.ExclusiveNumFree -= p.NumFree
 	c.ExclusiveAllocAmount -= p.AllocAmount
+	c.ExclusiveCosts = addCosts(c.ExclusiveCosts, p.Costs, -1)
 
 	io := p.WallTime - p.CpuTime
 	if io < 0 {
@@ -115,10 +139,26 @@ func (c *Call) Divide(d float32) *Call {
 	c.ExclusiveNumAlloc /= d
 	c.ExclusiveNumFree /= d
 	c.ExclusiveAllocAmount /= d
+	for k, v := range c.Costs {
+		c.Costs[k] = v / d
+	}
+	for k, v := range c.ExclusiveCosts {
+		c.ExclusiveCosts[k] = v / d
+	}
 
 	return c
 }
 
+// Copy returns a deep copy of the call.
+func (c *Call) Copy() *Call {
+	r := new(Call)
+	*r = *c
+	r.Costs = addCosts(nil, c.Costs, 1)
+	r.ExclusiveCosts = addCosts(nil, c.ExclusiveCosts, 1)
+
+	return r
+}
+
 type CallDiff struct {
 	Name           string
 	WallTime       float32
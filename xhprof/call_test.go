@@ -165,3 +165,17 @@ func TestDivide(t *testing.T) {
 
 	assert.Equal(t, expected, c1)
 }
+
+func TestCallGetFloat32Field(t *testing.T) {
+	c := &Call{
+		Count:          3,
+		WallTime:       100,
+		Costs:          map[string]float32{"Ir": 50},
+		ExclusiveCosts: map[string]float32{"Ir": 20},
+	}
+
+	assert.Equal(t, float32(3), c.GetFloat32Field("Count"))
+	assert.Equal(t, float32(100), c.GetFloat32Field("WallTime"))
+	assert.Equal(t, float32(50), c.GetFloat32Field("Costs.Ir"))
+	assert.Equal(t, float32(20), c.GetFloat32Field("ExclusiveCosts.Ir"))
+}
@@ -3,8 +3,11 @@ package xhprof
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -13,25 +16,88 @@ var (
 	formatSpecPattern    = regexp.MustCompile(`^# callgrind format$`)
 	formatVersionPattern = regexp.MustCompile(`^version: 1$`)
 	creatorPattern       = regexp.MustCompile(`^creator: .*$`)
-	headerPattern        = regexp.MustCompile(`^(\w+):\s*([[:graph:]]+)$`)
-	costsPattern         = regexp.MustCompile(`^(?:\d+\s*)+$`)
-	positionPattern      = regexp.MustCompile(`^(fl|fi|fn|cfi|cfl|cfn)=\s*(?:\((\d+)\))?\s*([[:graph:]]*)?$`)
-	callsPattern         = regexp.MustCompile(`^calls=\s*(\d+)\s*(\d+\s*)+$`)
+	headerPattern        = regexp.MustCompile(`^(\w+):\s*(.*)$`)
+	costsPattern         = regexp.MustCompile(`^(?:(?:[+-]?(?:0x[0-9a-fA-F]+|\d+)|\*)\s*)+$`)
+	positionPattern      = regexp.MustCompile(`^(fl|fi|fe|fn|cfi|cfl|cfn)=\s*(?:\((\d+)\))?\s*(.*)$`)
+	callsPattern         = regexp.MustCompile(`^calls=\s*(\d+)(?:\s+(?:[+-]?(?:0x[0-9a-fA-F]+|\d+)|\*))+\s*$`)
 	emptyPattern         = regexp.MustCompile(`^\s*$`)
+	eventUnitPattern     = regexp.MustCompile(`^(\w+?)_\((.+)\)$`)
 )
 
+// callgrindInternalFile is the file Xdebug uses for functions built into PHP.
+const callgrindInternalFile = "php:internal"
+
+// callgrindUnknownFile is the file valgrind uses for functions without debug
+// information.
+const callgrindUnknownFile = "???"
+
+// callgrindTimeUnits converts the unit of a time event, like Time_(10ns) of
+// Xdebug 3, into microseconds. Time without a unit is in microseconds.
+var callgrindTimeUnits = map[string]float32{
+	"10ns": 0.01,
+	"ns":   0.001,
+	"us":   1,
+	"µs":   1,
+	"ms":   1000,
+	"s":    1000000,
+}
+
+// ParseCallgrind reads a callgrind file, adding up the costs of all of its
+// parts. The parts are dumped during the same request, so main() keeps the
+// number of calls of the part that has the most.
 func ParseCallgrind(rd io.Reader) (*PairCallMap, error) {
+	parts, err := ParseCallgrindParts(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	m := SumPairCallMaps(parts)
+	if main, ok := m.M["main()"]; ok && len(parts) > 1 {
+		main.Count = 0
+		for _, part := range parts {
+			if pc, ok := part.M["main()"]; ok && pc.Count > main.Count {
+				main.Count = pc.Count
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// ParseCallgrindParts reads a callgrind file with one PairCallMap for each
+// of its parts (part: headers), e.g. the dumps of valgrind's --dump-every-bb.
+func ParseCallgrindParts(rd io.Reader) ([]*PairCallMap, error) {
 	p := NewCallgrindParser(rd)
 	return p.parseFile()
 }
 
 type CallgrindParser struct {
-	scanner   *bufio.Scanner
-	headers   map[string]string
-	positions map[string]string
-	pcMap     *PairCallMap
-	lastFn    string
-	lastCfn   string
+	scanner      *bufio.Scanner
+	headers      map[string]string
+	positions    map[string]string
+	pcMap        *PairCallMap
+	parts        []*PairCallMap
+	self         map[string]*PairCall
+	lastFn       string
+	lastCfn      string
+	lastCalls    int
+	events       []string
+	eventFields  []string
+	eventScales  []float32
+	numPositions int
+
+	// lineIndex is the column of the line number in the positions of cost
+	// lines, or -1 if the file has no line numbers. lastPositions holds the
+	// previous positions to resolve relative ones like "+2" or "*".
+	lineIndex     int
+	lastPositions []int64
+
+	// fnFile is the file of the current function (fl=), file the file of
+	// the current cost lines (fi=, fe=) and cfnFile the file of the called
+	// function (cfi=, cfl=).
+	fnFile  string
+	file    string
+	cfnFile string
 }
 
 func NewCallgrindParser(rd io.Reader) *CallgrindParser {
@@ -39,11 +105,95 @@ func NewCallgrindParser(rd io.Reader) *CallgrindParser {
 	p.scanner = bufio.NewScanner(rd)
 	p.headers = make(map[string]string)
 	p.positions = make(map[string]string)
+	p.numPositions = 1
+	p.newPart()
+
+	return p
+}
+
+// newPart resets the state of the parser for the next part of the file. Name
+// compression, events and positions carry over to the following parts.
+func (p *CallgrindParser) newPart() {
+	p.self = make(map[string]*PairCall)
+	p.lastFn = ""
+	p.lastCfn = ""
+	p.lastCalls = 0
+	p.lastPositions = make([]int64, p.numPositions)
+	p.fnFile = ""
+	p.file = ""
+	p.cfnFile = ""
 	p.pcMap = NewPairCallMap()
 	p.pcMap.NewPairCall("main()")
 	p.pcMap.M["main()"].Count = 1
 
-	return p
+	delete(p.headers, "summary")
+	delete(p.headers, "totals")
+}
+
+// hasPartData returns whether the current part contains any costs.
+func (p *CallgrindParser) hasPartData() bool {
+	return len(p.self) > 0 || len(p.pcMap.M) > 1
+}
+
+// finishPart completes the current part and validates it against its totals.
+func (p *CallgrindParser) finishPart() error {
+	if err := p.computeInclusiveCosts(); err != nil {
+		return err
+	}
+
+	if err := p.validateTotals(); err != nil {
+		return err
+	}
+
+	p.parts = append(p.parts, p.pcMap)
+	p.newPart()
+
+	return nil
+}
+
+// validateTotals compares the inclusive costs of main() with the totals: or
+// summary: header of the part. Only the first event is compared, because
+// Xdebug writes the peak memory into the summary. Xdebug and valgrind write
+// these headers once the program has finished, so their files without one
+// were cut off, e.g. because the request crashed.
+func (p *CallgrindParser) validateTotals() error {
+	part := "callgrind file"
+	if num, ok := p.headers["part"]; ok {
+		part = "part " + num + " of callgrind file"
+	}
+
+	totals, ok := p.headers["totals"]
+	if !ok {
+		totals, ok = p.headers["summary"]
+	}
+
+	if !ok {
+		creator := p.headers["creator"]
+		if strings.HasPrefix(creator, "xdebug") || strings.HasPrefix(creator, "callgrind") {
+			return fmt.Errorf("The %s has no summary, the file is probably truncated", part)
+		}
+
+		return nil
+	}
+
+	costs := strings.Fields(totals)
+	if len(costs) == 0 {
+		return nil
+	}
+
+	expected := new(PairCall)
+	if err := p.addCosts(expected, costs[:1]); err != nil {
+		return err
+	}
+
+	field := p.eventFields[0]
+	e := float64(expected.GetFloat32Field(field))
+	v := float64(p.pcMap.M["main()"].GetFloat32Field(field))
+	if math.Abs(e-v) > math.Max(1, 0.01*math.Abs(e)) {
+		return fmt.Errorf("The costs of the %s (%s %.0f) do not match its totals (%.0f), the file is probably truncated", part, p.events[0], v, e)
+	}
+
+	return nil
 }
 
 func (p *CallgrindParser) setHeader(k, v string) {
@@ -57,18 +207,26 @@ func (p *CallgrindParser) getOrSetPosName(kind, num, posName string) (name strin
 		return
 	}
 
+	// Files and functions are compressed independently of each other.
+	prefix := "fn:"
+	if kind != "fn" && kind != "cfn" {
+		prefix = "fl:"
+	}
+
 	if name == "" {
 		var ok bool
-		name, ok = p.positions["fn:"+num]
+		name, ok = p.positions[prefix+num]
 		if !ok {
 			err = errors.New("Position referenced without being defined")
 		}
 	} else {
-		if name == "{main}" {
+		if name == "{main}" && prefix == "fn:" {
 			name = "main()"
 		}
 
-		p.positions["fn:"+num] = name
+		if num != "" {
+			p.positions[prefix+num] = name
+		}
 	}
 
 	return
@@ -93,7 +251,7 @@ func (p *CallgrindParser) readLine() (text string, eof bool, err error) {
 	return
 }
 
-func (p *CallgrindParser) parseFile() (pcMap *PairCallMap, err error) {
+func (p *CallgrindParser) parseFile() (parts []*PairCallMap, err error) {
 	var text string
 	var eof bool
 	text, eof, err = p.readLine()
@@ -116,6 +274,7 @@ func (p *CallgrindParser) parseFile() (pcMap *PairCallMap, err error) {
 	}
 
 	if creatorPattern.MatchString(text) {
+		p.setHeader("creator", strings.TrimSpace(strings.TrimPrefix(text, "creator:")))
 		text, eof, err = p.readLine()
 		if eof || err != nil {
 			return
@@ -127,21 +286,96 @@ func (p *CallgrindParser) parseFile() (pcMap *PairCallMap, err error) {
 		return
 	}
 
-	if sum, ok := p.headers["summary"]; ok && p.pcMap.M["main()"].WallTime == 0 {
-		var wt float64
-		wt, err = strconv.ParseFloat(sum, 32)
+	if p.hasPartData() || len(p.parts) == 0 {
+		err = p.finishPart()
 		if err != nil {
 			return
 		}
-
-		p.pcMap.M["main()"].WallTime = float32(wt)
 	}
 
-	pcMap = p.pcMap
+	parts = p.parts
 
 	return
 }
 
+// computeInclusiveCosts derives the costs of main() from its own costs and the
+// calls it made. Functions that are never called, like the shutdown functions
+// of PHP or "(below main)" of valgrind, are attached to main().
+func (p *CallgrindParser) computeInclusiveCosts() error {
+	main := p.pcMap.M["main()"]
+	called := make(map[string]bool)
+	calls := make(map[string]*PairCall)
+	for name, info := range p.pcMap.M {
+		parent, child := parsePairName(name)
+		if parent == "" {
+			continue
+		}
+
+		called[child] = true
+		c, ok := calls[parent]
+		if !ok {
+			c = new(PairCall)
+			calls[parent] = c
+		}
+		c.Add(info)
+	}
+
+	roots := make(map[string]bool)
+	for fn := range p.self {
+		roots[fn] = true
+	}
+	for fn := range calls {
+		roots[fn] = true
+	}
+
+	for fn := range roots {
+		if called[fn] {
+			continue
+		}
+
+		inclusive := new(PairCall)
+		if self, ok := p.self[fn]; ok {
+			inclusive.Add(self)
+		}
+		if c, ok := calls[fn]; ok {
+			inclusive.Add(c)
+		}
+		inclusive.Count = 0
+
+		if fn != "main()" {
+			edge := p.pcMap.NewPairCall(pairName("main()", fn))
+			edge.Add(inclusive)
+			edge.Count++
+		}
+
+		main.Add(inclusive)
+	}
+
+	sum, ok := p.headers["summary"]
+	if !ok {
+		return nil
+	}
+
+	summary := new(PairCall)
+	if err := p.addCosts(summary, strings.Fields(sum)); err != nil {
+		return err
+	}
+
+	if main.WallTime == 0 {
+		main.WallTime = summary.WallTime
+	}
+	if main.Memory == 0 {
+		main.Memory = summary.Memory
+	}
+	for k, v := range summary.Costs {
+		if main.Costs[k] == 0 {
+			main.Costs = addCosts(main.Costs, map[string]float32{k: v}, 1)
+		}
+	}
+
+	return nil
+}
+
 func (p *CallgrindParser) parsePartData() (err error) {
 	eof := false
 	text := p.scanner.Text()
@@ -174,15 +408,120 @@ func (p *CallgrindParser) parseHeader() (err error) {
 	k := strings.TrimSpace(submatches[1])
 	v := strings.TrimSpace(submatches[2])
 
-	if k == "events" && v != "Time" {
-		err = errors.New("Only Time event is supported")
-	} else {
-		p.setHeader(submatches[1], submatches[2])
+	switch k {
+	case "part":
+		if p.hasPartData() {
+			err = p.finishPart()
+		}
+	case "events":
+		err = p.setEvents(strings.Fields(v))
+	case "positions":
+		positions := strings.Fields(v)
+		p.numPositions = len(positions)
+		if p.numPositions == 0 {
+			err = errors.New("Positions header must not be empty")
+		}
+
+		p.lineIndex = -1
+		for i, pos := range positions {
+			if pos == "line" {
+				p.lineIndex = i
+			}
+		}
+		p.lastPositions = make([]int64, p.numPositions)
 	}
 
+	p.setHeader(k, v)
+
 	return
 }
 
+// setEvents maps the events of the file onto the fields of PairCall. Time is
+// stored as WallTime in microseconds and Memory as Memory, the events written
+// by WriteCallgrind map back onto their fields, and all other events are kept
+// in the Costs map.
+func (p *CallgrindParser) setEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("Events header must define at least one event")
+	}
+
+	p.events = events
+	p.eventFields = make([]string, len(events))
+	p.eventScales = make([]float32, len(events))
+	for i, event := range events {
+		name, unit := event, ""
+		if submatches := eventUnitPattern.FindStringSubmatch(event); submatches != nil {
+			name, unit = submatches[1], submatches[2]
+		}
+
+		p.eventFields[i] = costsPrefix + event
+		p.eventScales[i] = 1
+
+		if name == "Time" {
+			p.eventFields[i] = "WallTime"
+			if scale, ok := callgrindTimeUnits[unit]; ok {
+				p.eventScales[i] = scale
+			}
+			continue
+		}
+
+		for _, e := range callgrindEvents {
+			if e.Name == name {
+				p.eventFields[i] = e.Field
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// addCosts adds the cost columns of a cost line to pc.
+func (p *CallgrindParser) addCosts(pc *PairCall, costs []string) error {
+	if p.events == nil {
+		if err := p.setEvents([]string{"Time"}); err != nil {
+			return err
+		}
+	}
+
+	if len(costs) > len(p.events) {
+		return errors.New("Costs expression has more costs than events are defined")
+	}
+
+	for i, c := range costs {
+		if c == "*" {
+			return errors.New("Costs expression has an invalid cost *, which is only allowed for positions")
+		}
+
+		cost, err := parseCallgrindNumber(c)
+		if err != nil {
+			return err
+		}
+
+		v := float32(cost) * p.eventScales[i]
+		switch field := p.eventFields[i]; field {
+		case "WallTime":
+			pc.WallTime += v
+		case "CpuTime":
+			pc.CpuTime += v
+		case "Memory":
+			pc.Memory += v
+		case "PeakMemory":
+			pc.PeakMemory += v
+		case "NumAlloc":
+			pc.NumAlloc += v
+		case "NumFree":
+			pc.NumFree += v
+		case "AllocAmount":
+			pc.AllocAmount += v
+		default:
+			pc.Costs = addCosts(pc.Costs, map[string]float32{strings.TrimPrefix(field, costsPrefix): v}, 1)
+		}
+	}
+
+	return nil
+}
+
 func (p *CallgrindParser) parsePosition() (err error) {
 	text := p.scanner.Text()
 	submatches := positionPattern.FindStringSubmatch(text)
@@ -190,18 +529,32 @@ func (p *CallgrindParser) parsePosition() (err error) {
 	posNum := strings.TrimSpace(submatches[2])
 	posName := strings.TrimSpace(submatches[3])
 
-	// Ignore file information
-	if posType != "fn" && posType != "cfn" {
+	posName, err = p.getOrSetPosName(posType, posNum, posName)
+	if err != nil {
 		return
 	}
 
-	posName, err = p.getOrSetPosName(posType, posNum, posName)
-
-	if posType == "fn" {
+	switch posType {
+	case "fl":
+		p.fnFile = posName
+		p.file = posName
+	case "fi", "fe":
+		p.file = posName
+	case "cfi", "cfl":
+		p.cfnFile = posName
+	case "fn":
 		p.lastFn = posName
 		p.lastCfn = ""
-	} else if posType == "cfn" {
+		p.file = p.fnFile
+		p.setSourceFile(posName, p.fnFile)
+	case "cfn":
 		p.lastCfn = posName
+		if p.cfnFile != "" {
+			p.setSourceFile(posName, p.cfnFile)
+		} else {
+			p.setSourceFile(posName, p.fnFile)
+		}
+		p.cfnFile = ""
 	}
 
 	if p.lastFn != "" && p.lastCfn != "" {
@@ -224,6 +577,7 @@ func (p *CallgrindParser) parseCalls() (err error) {
 	}
 
 	p.pcMap.M[pairName(p.lastFn, p.lastCfn)].Count += count
+	p.lastCalls = count
 	eof := false
 	text, eof, err = p.readLine()
 	if eof || err != nil {
@@ -240,26 +594,251 @@ func (p *CallgrindParser) parseCalls() (err error) {
 }
 
 func (p *CallgrindParser) parseCosts(callCosts bool) (err error) {
-	if !callCosts && !(p.lastFn == "main()" && p.lastCfn == "") {
-		return
+	if p.lastFn == "" {
+		return errors.New("Costs expression encountered without function being defined")
 	}
 
-	text := p.scanner.Text()
-	match := costsPattern.FindString(text)
-	cost, err := strconv.ParseFloat(strings.TrimSpace(strings.Split(match, " ")[1]), 32)
+	fields := strings.Fields(p.scanner.Text())
+	if len(fields) < p.numPositions {
+		return errors.New("Costs expression has less positions than defined")
+	}
+
+	line, err := p.parsePositions(fields[:p.numPositions])
 	if err != nil {
-		return
+		return err
 	}
 
-	if p.lastFn == "" {
-		err = errors.New("Costs expression encountered without function being defined")
-		return
+	costs := new(PairCall)
+	if err = p.addCosts(costs, fields[p.numPositions:]); err != nil {
+		return err
+	}
+
+	if callCosts {
+		p.pcMap.M[pairName(p.lastFn, p.lastCfn)].Add(costs)
+		if line > 0 {
+			p.pcMap.NewSource(p.lastFn).AddCallSite(p.lastCfn, p.file, line, p.lastCalls)
+		}
+		p.lastCfn = ""
+
+		return nil
+	}
+
+	pc, ok := p.self[p.lastFn]
+	if !ok {
+		pc = new(PairCall)
+		p.self[p.lastFn] = pc
+	}
+	pc.Add(costs)
+
+	// Line 0 means the line is unknown, and lines of inlined files (fi=, fe=)
+	// do not belong to the function's source. Xdebug writes the line of the
+	// caller for functions built into PHP.
+	if line > 0 && p.file == p.fnFile && p.fnFile != callgrindInternalFile {
+		src := p.pcMap.NewSource(p.lastFn)
+		src.AddLineCosts(line, costs)
+		if src.Line == 0 {
+			src.Line = line
+		}
+	}
+
+	return nil
+}
+
+// parsePositions resolves the positions of a cost line, which can be
+// relative to the previous cost line (e.g. "+2", "-1" or "*" for the same),
+// and returns the line number.
+func (p *CallgrindParser) parsePositions(positions []string) (line int, err error) {
+	for i, pos := range positions {
+		if pos == "*" {
+			continue
+		}
+
+		var v int64
+		if v, err = parseCallgrindNumber(pos); err != nil {
+			return
+		}
+
+		if pos[0] == '+' || pos[0] == '-' {
+			p.lastPositions[i] += v
+		} else {
+			p.lastPositions[i] = v
+		}
 	}
 
-	p.pcMap.M[pairName(p.lastFn, p.lastCfn)].WallTime += float32(cost)
-	if p.lastFn == "main()" && p.lastCfn != "" {
-		p.pcMap.M["main()"].WallTime += float32(cost)
+	if p.lineIndex >= 0 {
+		line = int(p.lastPositions[p.lineIndex])
 	}
 
 	return
 }
+
+// parseCallgrindNumber parses a position or cost, which is hexadecimal with a
+// "0x" prefix and decimal otherwise, even with leading zeros like "010".
+func parseCallgrindNumber(s string) (int64, error) {
+	sign := ""
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		sign, s = s[:1], s[1:]
+	}
+
+	if strings.HasPrefix(s, "0x") {
+		return strconv.ParseInt(sign+s[2:], 16, 64)
+	}
+
+	return strconv.ParseInt(sign+s, 10, 64)
+}
+
+// setSourceFile records the file of function fn, unless it is already known
+// or the function is internal to PHP or in an unknown file.
+func (p *CallgrindParser) setSourceFile(fn, file string) {
+	if file == "" || file == callgrindInternalFile || file == callgrindUnknownFile {
+		return
+	}
+
+	if src := p.pcMap.NewSource(fn); src.File == "" {
+		src.File = file
+	}
+}
+
+type callgrindEvent struct {
+	Name  string
+	Field string
+	Label string
+}
+
+var callgrindEvents = []callgrindEvent{
+	{Name: "Wall", Field: "WallTime", Label: "Wall-Time (microseconds)"},
+	{Name: "CPU", Field: "CpuTime", Label: "CPU-Time (microseconds)"},
+	{Name: "Memory", Field: "Memory", Label: "Memory (bytes)"},
+	{Name: "PeakMemory", Field: "PeakMemory", Label: "Peak Memory (bytes)"},
+	{Name: "NumAlloc", Field: "NumAlloc", Label: "Number of Allocations"},
+	{Name: "NumFree", Field: "NumFree", Label: "Number of Frees"},
+	{Name: "AllocAmount", Field: "AllocAmount", Label: "Amount of allocated Memory (bytes)"},
+}
+
+// WriteCallgrind writes the map in the callgrind format, with one event for
+// each PairCall metric. Negative costs, e.g. memory that was freed, are
+// written as 0 because callgrind only supports positive costs. Functions
+// without source are in the file ???, so that they are not taken to be in the
+// file of the function before them.
+func WriteCallgrind(w io.Writer, m *PairCallMap) error {
+	self := make(map[string]*PairCall)
+	children := make(map[string][]string)
+	for name, info := range m.M {
+		parent, child := parsePairName(name)
+
+		c, ok := self[child]
+		if !ok {
+			c = new(PairCall)
+			self[child] = c
+		}
+		c.Add(info)
+
+		if parent == "" {
+			continue
+		}
+
+		p, ok := self[parent]
+		if !ok {
+			p = new(PairCall)
+			self[parent] = p
+		}
+		p.Subtract(info)
+
+		children[parent] = append(children[parent], child)
+	}
+
+	fns := make([]string, 0, len(self))
+	for fn := range self {
+		if fn != "main()" {
+			fns = append(fns, fn)
+		}
+	}
+	sort.Strings(fns)
+	if _, ok := self["main()"]; ok {
+		fns = append([]string{"main()"}, fns...)
+	}
+
+	ids := make(map[string]int)
+	compressed := func(name string) string {
+		if id, ok := ids[name]; ok {
+			return fmt.Sprintf("(%d)", id)
+		}
+
+		ids[name] = len(ids) + 1
+		return fmt.Sprintf("(%d) %s", ids[name], name)
+	}
+
+	fileIds := make(map[string]int)
+	compressedFile := func(name string) string {
+		if name == "" {
+			name = callgrindUnknownFile
+		}
+
+		if id, ok := fileIds[name]; ok {
+			return fmt.Sprintf("(%d)", id)
+		}
+
+		fileIds[name] = len(fileIds) + 1
+		return fmt.Sprintf("(%d) %s", fileIds[name], name)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# callgrind format\nversion: 1\ncreator: tideways-toolkit\npositions: line\n")
+
+	names := make([]string, 0, len(callgrindEvents))
+	for _, e := range callgrindEvents {
+		fmt.Fprintf(bw, "event: %s : %s\n", e.Name, e.Label)
+		names = append(names, e.Name)
+	}
+	fmt.Fprintf(bw, "events: %s\n", strings.Join(names, " "))
+
+	if main, ok := m.M["main()"]; ok {
+		fmt.Fprintf(bw, "summary: %s\n", callgrindCosts(main))
+	}
+
+	for _, fn := range fns {
+		src, ok := m.Sources[fn]
+		if !ok {
+			src = newSource()
+		}
+
+		fmt.Fprintf(bw, "\nfl=%s\n", compressedFile(src.File))
+		fmt.Fprintf(bw, "fn=%s\n%d %s\n", compressed(fn), src.Line, callgrindCosts(self[fn]))
+
+		sort.Strings(children[fn])
+		for _, child := range children[fn] {
+			info := m.M[pairName(fn, child)]
+
+			line, target := 0, 0
+			for _, c := range src.CallSites {
+				if c.Function == child {
+					line = c.Line
+					break
+				}
+			}
+			file := ""
+			if s, ok := m.Sources[child]; ok {
+				file, target = s.File, s.Line
+			}
+			fmt.Fprintf(bw, "cfi=%s\n", compressedFile(file))
+
+			fmt.Fprintf(bw, "cfn=%s\ncalls=%d %d\n%d %s\n", compressed(child), info.Count, target, line, callgrindCosts(info))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func callgrindCosts(p *PairCall) string {
+	costs := make([]string, 0, len(callgrindEvents))
+	for _, e := range callgrindEvents {
+		v := math.Floor(float64(p.GetFloat32Field(e.Field)) + 0.5)
+		if v < 0 {
+			v = 0
+		}
+
+		costs = append(costs, strconv.FormatFloat(v, 'f', 0, 64))
+	}
+
+	return strings.Join(costs, " ")
+}
@@ -1,7 +1,9 @@
 package xhprof
 
 import (
+	"bytes"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,6 +30,31 @@ func TestParseCallgrind(t *testing.T) {
 				WallTime: 300,
 			},
 		},
+		Sources: map[string]*Source{
+			"main()": &Source{
+				File:  "file1.c",
+				Line:  16,
+				Lines: map[int]*PairCall{16: &PairCall{WallTime: 20}},
+				CallSites: []*CallSite{
+					&CallSite{Function: "func1", File: "file1.c", Line: 16, Count: 1},
+					&CallSite{Function: "func2", File: "file1.c", Line: 16, Count: 3},
+				},
+			},
+			"func1": &Source{
+				File:  "file1.c",
+				Line:  51,
+				Lines: map[int]*PairCall{51: &PairCall{WallTime: 100}},
+				CallSites: []*CallSite{
+					&CallSite{Function: "func2", File: "file1.c", Line: 51, Count: 2},
+				},
+			},
+			"func2": &Source{
+				File:      "file2.c",
+				Line:      20,
+				Lines:     map[int]*PairCall{20: &PairCall{WallTime: 700}},
+				CallSites: []*CallSite{},
+			},
+		},
 	}
 
 	f, err := os.Open("testdata/callgrind-simple.out")
@@ -38,3 +65,359 @@ func TestParseCallgrind(t *testing.T) {
 
 	assert.EqualValues(t, expected, m)
 }
+
+func TestParseCallgrindEvents(t *testing.T) {
+	expected := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				Count:    1,
+				WallTime: 510,
+				Memory:   1088,
+			},
+			"main()==>foo": &PairCall{
+				Count:    2,
+				WallTime: 500,
+				Memory:   1024,
+			},
+		},
+		Sources: map[string]*Source{
+			"main()": &Source{
+				File:  "/var/www/index.php",
+				Line:  2,
+				Lines: map[int]*PairCall{2: &PairCall{WallTime: 10, Memory: 64}},
+				CallSites: []*CallSite{
+					&CallSite{Function: "foo", File: "/var/www/index.php", Line: 3, Count: 2},
+				},
+			},
+			"foo": &Source{
+				File:      "/var/www/lib.php",
+				Line:      5,
+				Lines:     map[int]*PairCall{5: &PairCall{WallTime: 500, Memory: 1024}},
+				CallSites: []*CallSite{},
+			},
+		},
+	}
+
+	f, err := os.Open("testdata/callgrind-events.out")
+	require.Nil(t, err)
+	defer f.Close()
+
+	m, err := ParseCallgrind(f)
+	require.Nil(t, err)
+
+	assert.EqualValues(t, expected, m)
+}
+
+func TestParseCallgrindValgrindEvents(t *testing.T) {
+	data := `events: Ir Dr
+fn=(1) (below main)
+1 10 2
+cfn=(2) main
+calls=1 0
+1 305 40
+fn=(2)
+5 100 20
+cfn=(3) compute
+calls=4 0
+6 200 20
+6 5
+`
+
+	m, err := ParseCallgrind(strings.NewReader(data))
+	require.Nil(t, err)
+
+	require.Contains(t, m.M, "main()==>(below main)")
+	assert.Equal(t, 1, m.M["main()==>(below main)"].Count)
+	assert.Equal(t, map[string]float32{"Ir": 315, "Dr": 42}, m.M["main()==>(below main)"].Costs)
+	assert.Equal(t, map[string]float32{"Ir": 315, "Dr": 42}, m.M["main()"].Costs)
+	assert.Equal(t, map[string]float32{"Ir": 200, "Dr": 20}, m.M["main==>compute"].Costs)
+
+	p := m.Flatten()
+	c := p.GetCall("main")
+	require.NotNil(t, c)
+	assert.Equal(t, float32(305), c.GetFloat32Field("Costs.Ir"))
+	assert.Equal(t, float32(105), c.GetFloat32Field("ExclusiveCosts.Ir"))
+
+	_, err = ParseCallgrind(strings.NewReader("events: Ir\nfn=foo\n1 2 3\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseCallgrindPositions(t *testing.T) {
+	data := `positions: instr line
+events: Ir
+fl=(1) main.c
+fn=(1) main
+0x10 10 5
++4 +2 3
+* * 2
+cfl=(2) util.c
+cfn=(2) helper
+calls=2 0x40 7
++8 -1 20
+fi=(3) inline.h
++2 100 4
+fe=(1)
+-2 11 1
+
+fn=(2)
+0x40 7 20
+`
+
+	m, err := ParseCallgrind(strings.NewReader(data))
+	require.Nil(t, err)
+
+	main := m.Sources["main"]
+	require.NotNil(t, main)
+	assert.Equal(t, "main.c", main.File)
+	assert.Equal(t, 10, main.Line)
+	assert.Equal(t, []int{10, 11, 12}, main.SortedLines())
+	assert.Equal(t, map[string]float32{"Ir": 5}, main.Lines[10].Costs)
+	assert.Equal(t, map[string]float32{"Ir": 5}, main.Lines[12].Costs)
+	assert.Equal(t, map[string]float32{"Ir": 1}, main.Lines[11].Costs)
+	assert.Equal(t, []*CallSite{&CallSite{Function: "helper", File: "main.c", Line: 11, Count: 2}}, main.CallSites)
+
+	helper := m.Sources["helper"]
+	require.NotNil(t, helper)
+	assert.Equal(t, "util.c", helper.File)
+	assert.Equal(t, 7, helper.Line)
+
+	p := m.Flatten()
+	c := p.GetCall("helper")
+	require.NotNil(t, c)
+	assert.Equal(t, "util.c", c.File)
+	assert.Equal(t, 7, c.Line)
+}
+
+func TestParseCallgrindNumbers(t *testing.T) {
+	data := `positions: line
+events: Ir Dr
+fl=(1) main.c
+fn=(1) main
+010 0x1A 0x10
++0x2 020 +5
+cfn=(2) helper
+calls=2 012
+014 0xff 0
+
+fn=(2)
+09 255 0
+`
+
+	m, err := ParseCallgrind(strings.NewReader(data))
+	require.Nil(t, err)
+
+	main := m.Sources["main"]
+	require.NotNil(t, main)
+	assert.Equal(t, []int{10, 12, 14}, main.SortedLines())
+	assert.Equal(t, map[string]float32{"Ir": 26, "Dr": 16}, main.Lines[10].Costs)
+	assert.Equal(t, map[string]float32{"Ir": 20, "Dr": 5}, main.Lines[12].Costs)
+	assert.Equal(t, map[string]float32{"Ir": 255, "Dr": 0}, m.M["main==>helper"].Costs)
+	assert.Equal(t, 9, m.Sources["helper"].Line)
+
+	_, err = ParseCallgrind(strings.NewReader(strings.Replace(data, "020 +5", "020 *", 1)))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid cost *")
+}
+
+func TestParseCallgrindParts(t *testing.T) {
+	f, err := os.Open("testdata/callgrind-parts.out")
+	require.Nil(t, err)
+	defer f.Close()
+
+	parts, err := ParseCallgrindParts(f)
+	require.Nil(t, err)
+	require.Len(t, parts, 2)
+
+	assert.Equal(t, map[string]float32{"Ir": 150}, parts[0].M["main()"].Costs)
+	assert.Equal(t, 1, parts[0].M["main==>work"].Count)
+	assert.Equal(t, map[string]float32{"Ir": 80}, parts[1].M["main()"].Costs)
+	assert.Equal(t, 2, parts[1].M["main==>work"].Count)
+	assert.Equal(t, "app.c", parts[1].Sources["main"].File)
+
+	f2 := NewFile("testdata/callgrind-parts.out", "callgrind")
+	m, err := f2.GetPairCallMap()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]float32{"Ir": 230}, m.M["main()"].Costs)
+	assert.Equal(t, 3, m.M["main==>work"].Count)
+
+	// The parts belong to one request, which called main() once.
+	assert.Equal(t, 1, parts[0].M["main()"].Count)
+	assert.Equal(t, 1, parts[1].M["main()"].Count)
+	assert.Equal(t, 1, m.M["main()"].Count)
+
+	maps, err := f2.GetPairCallMaps()
+	require.Nil(t, err)
+	assert.Len(t, maps, 2)
+}
+
+func TestParseCallgrindTruncated(t *testing.T) {
+	data := `version: 1
+creator: xdebug 3.0.0 (PHP 7.4.0)
+events: Time_(10ns) Memory_(bytes)
+
+fl=(1) /var/www/lib.php
+fn=(1) foo
+5 50000 1024
+`
+
+	_, err := ParseCallgrind(strings.NewReader(data))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "truncated")
+
+	data = `events: Ir
+totals: 1000
+fn=(1) main
+1 10
+cfn=(2) foo
+calls=1 0
+2 20
+`
+
+	_, err = ParseCallgrind(strings.NewReader(data))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "do not match its totals (1000)")
+
+	_, err = ParseCallgrind(strings.NewReader(strings.Replace(data, "1000", "30", 1)))
+	assert.Nil(t, err)
+}
+
+func TestWriteParseCallgrind(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime:    1000,
+				Count:       1,
+				CpuTime:     400,
+				Memory:      1500,
+				PeakMemory:  2000,
+				NumAlloc:    20,
+				NumFree:     10,
+				AllocAmount: 4096,
+			},
+			"main()==>foo": &PairCall{
+				WallTime:    500,
+				Count:       2,
+				CpuTime:     200,
+				Memory:      700,
+				PeakMemory:  1000,
+				NumAlloc:    5,
+				AllocAmount: 2048,
+			},
+			"foo==>bar": &PairCall{
+				WallTime: 200,
+				Count:    10,
+				CpuTime:  100,
+				Memory:   300,
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	err := WriteCallgrind(&b, m)
+	require.Nil(t, err)
+
+	parsed, err := ParseCallgrind(&b)
+	require.Nil(t, err)
+	assert.EqualValues(t, m, parsed)
+}
+
+func TestWriteParseCallgrindSources(t *testing.T) {
+	m := NewPairCallMap()
+	m.NewPairCall("main()").Add(&PairCall{Count: 1, WallTime: 1000})
+	m.NewPairCall("main()==>baz").Add(&PairCall{Count: 1, WallTime: 600})
+	m.NewPairCall("main()==>foo").Add(&PairCall{Count: 2, WallTime: 300})
+	m.NewPairCall("baz==>bar").Add(&PairCall{Count: 3, WallTime: 100})
+	m.NewPairCall("foo==>qux").Add(&PairCall{Count: 1, WallTime: 50})
+	m.NewSource("main()").File = "/var/www/index.php"
+	m.NewSource("baz").File = "/var/www/lib.php"
+	m.NewSource("qux").File = "/var/www/lib.php"
+
+	var b bytes.Buffer
+	require.Nil(t, WriteCallgrind(&b, m))
+	assert.Contains(t, b.String(), "cfi=(3) ???\ncfn=(3) foo\n")
+
+	parsed, err := ParseCallgrind(&b)
+	require.Nil(t, err)
+	assert.EqualValues(t, m.M, parsed.M)
+
+	// Functions without source are not taken to be in the file of the
+	// function written before them, nor in the file of their caller.
+	for fn, file := range map[string]string{"main()": "/var/www/index.php", "baz": "/var/www/lib.php", "qux": "/var/www/lib.php"} {
+		require.Contains(t, parsed.Sources, fn)
+		assert.Equal(t, file, parsed.Sources[fn].File, fn)
+	}
+	assert.NotContains(t, parsed.Sources, "bar")
+	assert.NotContains(t, parsed.Sources, "foo")
+}
+
+func TestWriteCallgrind(t *testing.T) {
+	expected := `# callgrind format
+version: 1
+creator: tideways-toolkit
+positions: line
+event: Wall : Wall-Time (microseconds)
+event: CPU : CPU-Time (microseconds)
+event: Memory : Memory (bytes)
+event: PeakMemory : Peak Memory (bytes)
+event: NumAlloc : Number of Allocations
+event: NumFree : Number of Frees
+event: AllocAmount : Amount of allocated Memory (bytes)
+events: Wall CPU Memory PeakMemory NumAlloc NumFree AllocAmount
+summary: 1000 400 1500 2000 20 10 4096
+
+fl=(1) ???
+fn=(1) main()
+0 500 200 800 1000 15 10 2048
+cfi=(1)
+cfn=(2) foo
+calls=2 0
+0 500 200 700 1000 5 0 2048
+
+fl=(1)
+fn=(3) bar
+0 200 100 0 0 0 0 0
+
+fl=(1)
+fn=(2)
+0 300 100 1000 1000 5 0 2048
+cfi=(1)
+cfn=(3)
+calls=10 0
+0 200 100 0 0 0 0 0
+`
+
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime:    1000,
+				Count:       1,
+				CpuTime:     400,
+				Memory:      1500,
+				PeakMemory:  2000,
+				NumAlloc:    20,
+				NumFree:     10,
+				AllocAmount: 4096,
+			},
+			"main()==>foo": &PairCall{
+				WallTime:    500,
+				Count:       2,
+				CpuTime:     200,
+				Memory:      700,
+				PeakMemory:  1000,
+				NumAlloc:    5,
+				AllocAmount: 2048,
+			},
+			"foo==>bar": &PairCall{
+				WallTime: 200,
+				Count:    10,
+				CpuTime:  100,
+				Memory:   -300,
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	err := WriteCallgrind(&b, m)
+	require.Nil(t, err)
+	assert.Equal(t, expected, b.String())
+}
@@ -0,0 +1,145 @@
+package xhprof
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// exportThreshold is the ratio of main()'s wall time below which stacks are
+// folded into their caller when exporting call trees to other formats.
+const exportThreshold = 0.00001
+
+// CallNode is a single frame of a call tree. XHProf data only records
+// parent==>child edges, so the inclusive costs of a node are estimated by
+// splitting the costs of each edge across all the stacks its parent
+// appears in, proportionally to the parent's wall time in that stack.
+type CallNode struct {
+	Name      string
+	Inclusive *PairCall
+	Parent    *CallNode
+	Children  []*CallNode
+
+	count float32
+}
+
+func newCallNode(name string, parent *CallNode, edge *PairCall, ratio float32) *CallNode {
+	n := new(CallNode)
+	n.Name = name
+	n.Parent = parent
+	n.count = float32(edge.Count) * ratio
+	n.Inclusive = &PairCall{
+		Count:       int(math.Floor(float64(n.count) + 0.5)),
+		WallTime:    edge.WallTime * ratio,
+		CpuTime:     edge.CpuTime * ratio,
+		Memory:      edge.Memory * ratio,
+		PeakMemory:  edge.PeakMemory * ratio,
+		NumAlloc:    edge.NumAlloc * ratio,
+		NumFree:     edge.NumFree * ratio,
+		AllocAmount: edge.AllocAmount * ratio,
+		Costs:       addCosts(nil, edge.Costs, ratio),
+	}
+
+	return n
+}
+
+// Exclusive returns the costs of the node minus the costs of its children.
+func (n *CallNode) Exclusive() *PairCall {
+	e := n.Inclusive.Copy()
+	for _, c := range n.Children {
+		e.Subtract(c.Inclusive)
+	}
+	e.Count = n.Inclusive.Count
+
+	return e
+}
+
+// Stack returns the names of all frames from the root down to this node.
+func (n *CallNode) Stack() []string {
+	depth := 0
+	for p := n; p != nil; p = p.Parent {
+		depth++
+	}
+
+	stack := make([]string, depth)
+	for p := n; p != nil; p = p.Parent {
+		depth--
+		stack[depth] = p.Name
+	}
+
+	return stack
+}
+
+// Walk calls fn for the node and all of its descendants in depth-first
+// order, parents before their children.
+func (n *CallNode) Walk(fn func(*CallNode)) {
+	fn(n)
+	for _, c := range n.Children {
+		c.Walk(fn)
+	}
+}
+
+// CallTree expands the parent==>child edges of the map into a tree rooted at
+// main(). Subtrees with less than threshold (a ratio) of main()'s wall time
+// are pruned, and recursive edges back into a function that is already on the
+// stack are not followed. Their costs remain part of the caller. A threshold
+// of 0 expands the complete tree, which can get very large for big profiles.
+func (m *PairCallMap) CallTree(threshold float32) (*CallNode, error) {
+	main, ok := m.M["main()"]
+	if !ok {
+		return nil, errors.New("Call map has no main()")
+	}
+
+	totals := make(map[string]*PairCall)
+	for name, info := range m.M {
+		_, child := parsePairName(name)
+		total, ok := totals[child]
+		if !ok {
+			total = new(PairCall)
+			totals[child] = total
+		}
+
+		total.Add(info)
+	}
+
+	childrenMap := m.GetChildrenMap()
+	for _, children := range childrenMap {
+		sort.Strings(children)
+	}
+
+	minWt := float32(math.Abs(float64(threshold * main.WallTime)))
+	onStack := map[string]bool{"main()": true}
+
+	var expand func(n *CallNode, ratio float32)
+	expand = func(n *CallNode, ratio float32) {
+		for _, child := range childrenMap[n.Name] {
+			if onStack[child] {
+				continue
+			}
+
+			c := newCallNode(child, n, m.M[pairName(n.Name, child)], ratio)
+			if minWt > 0 && float32(math.Abs(float64(c.Inclusive.WallTime))) < minWt {
+				continue
+			}
+
+			var childRatio float32
+			total := totals[child]
+			if total.WallTime != 0 {
+				childRatio = c.Inclusive.WallTime / total.WallTime
+			} else if total.Count != 0 {
+				childRatio = c.count / float32(total.Count)
+			}
+
+			n.Children = append(n.Children, c)
+
+			onStack[child] = true
+			expand(c, childRatio)
+			delete(onStack, child)
+		}
+	}
+
+	root := newCallNode("main()", nil, main, 1)
+	expand(root, 1)
+
+	return root, nil
+}
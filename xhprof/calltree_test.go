@@ -0,0 +1,98 @@
+package xhprof
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallTree(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 1000,
+				Count:    1,
+			},
+			"main()==>a": &PairCall{
+				WallTime: 600,
+				Count:    1,
+			},
+			"main()==>b": &PairCall{
+				WallTime: 400,
+				Count:    1,
+			},
+			"a==>c": &PairCall{
+				WallTime: 300,
+				Count:    3,
+			},
+			"b==>c": &PairCall{
+				WallTime: 100,
+				Count:    1,
+			},
+			"c==>d": &PairCall{
+				WallTime: 200,
+				Count:    8,
+			},
+			"d==>c": &PairCall{
+				WallTime: 10,
+				Count:    1,
+			},
+		},
+	}
+
+	root, err := m.CallTree(0)
+	require.Nil(t, err)
+
+	assert.Equal(t, "main()", root.Name)
+	assert.Equal(t, float32(1000), root.Inclusive.WallTime)
+	require.Len(t, root.Children, 2)
+
+	a := root.Children[0]
+	assert.Equal(t, "a", a.Name)
+	require.Len(t, a.Children, 1)
+
+	c := a.Children[0]
+	assert.Equal(t, "c", c.Name)
+	assert.Equal(t, float32(300), c.Inclusive.WallTime)
+	require.Len(t, c.Children, 1)
+
+	d := c.Children[0]
+	assert.Equal(t, "d", d.Name)
+	assert.Equal(t, c, d.Parent)
+	assert.InDelta(t, 146.34, d.Inclusive.WallTime, 0.01)
+	assert.Equal(t, 6, d.Inclusive.Count)
+	assert.Empty(t, d.Children)
+	assert.Equal(t, []string{"main()", "a", "c", "d"}, d.Stack())
+	assert.Equal(t, float32(300), a.Exclusive().WallTime)
+	assert.InDelta(t, 153.66, c.Exclusive().WallTime, 0.01)
+
+	names := make([]string, 0, 7)
+	root.Walk(func(n *CallNode) {
+		names = append(names, n.Name)
+	})
+	assert.Equal(t, []string{"main()", "a", "c", "d", "b", "c", "d"}, names)
+
+	d = root.Children[1].Children[0].Children[0]
+	assert.InDelta(t, 48.78, d.Inclusive.WallTime, 0.01)
+
+	root, err = m.CallTree(0.2)
+	require.Nil(t, err)
+	require.Len(t, root.Children, 2)
+	assert.Len(t, root.Children[0].Children, 1)
+	assert.Empty(t, root.Children[1].Children)
+}
+
+func TestCallTreeWithoutMain(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"foo==>bar": &PairCall{
+				WallTime: 200,
+				Count:    10,
+			},
+		},
+	}
+
+	_, err := m.CallTree(0)
+	assert.NotNil(t, err)
+}
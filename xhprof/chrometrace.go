@@ -0,0 +1,134 @@
+package xhprof
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	chromeTracePid = 1
+	chromeTraceTid = 1
+)
+
+type chromeTraceFile struct {
+	TraceEvents     []*chromeTraceEvent `json:"traceEvents"`
+	DisplayTimeUnit string              `json:"displayTimeUnit"`
+	OtherData       map[string]string   `json:"otherData,omitempty"`
+}
+
+type chromeTraceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+func newChromeTraceFile(thread string, meta map[string]string) *chromeTraceFile {
+	file := &chromeTraceFile{
+		DisplayTimeUnit: "ms",
+		OtherData:       map[string]string{"exporter": "tideways-toolkit"},
+	}
+	for k, v := range meta {
+		file.OtherData[k] = v
+	}
+
+	file.TraceEvents = append(file.TraceEvents,
+		&chromeTraceEvent{Name: "process_name", Ph: "M", Pid: chromeTracePid, Tid: chromeTraceTid, Args: map[string]interface{}{"name": "PHP"}},
+		&chromeTraceEvent{Name: "thread_name", Ph: "M", Pid: chromeTracePid, Tid: chromeTraceTid, Args: map[string]interface{}{"name": thread}},
+	)
+
+	return file
+}
+
+func (f *chromeTraceFile) add(name string, ts, dur float64, args map[string]interface{}) {
+	f.TraceEvents = append(f.TraceEvents, &chromeTraceEvent{
+		Name: name,
+		Cat:  "php",
+		Ph:   "X",
+		Ts:   ts,
+		Dur:  dur,
+		Pid:  chromeTracePid,
+		Tid:  chromeTraceTid,
+		Args: args,
+	})
+}
+
+// WriteChromeTrace writes the call tree of the map in the Chrome Trace Event
+// format understood by chrome://tracing and Perfetto. Profiles do not record
+// when a function was called, so the calls of each frame are stacked by their
+// inclusive wall time instead, the most expensive first, like the left-heavy
+// view of speedscope. Use WriteChromeTraceTimeline for the actual order.
+func WriteChromeTrace(w io.Writer, m *PairCallMap) error {
+	root, err := m.CallTree(exportThreshold)
+	if err != nil {
+		return err
+	}
+
+	file := newChromeTraceFile("Stacked by inclusive time", m.Meta)
+
+	var layout func(n *CallNode, ts, end float64)
+	layout = func(n *CallNode, ts, end float64) {
+		dur := math.Min(math.Max(float64(n.Inclusive.WallTime), 0), end-ts)
+		if dur <= 0 {
+			return
+		}
+
+		args := map[string]interface{}{
+			"count":   n.Inclusive.Count,
+			"excl_wt": n.Exclusive().WallTime,
+		}
+		if n.Inclusive.CpuTime != 0 {
+			args["cpu"] = n.Inclusive.CpuTime
+		}
+		if n.Inclusive.Memory != 0 {
+			args["memory"] = n.Inclusive.Memory
+		}
+		file.add(n.Name, ts, dur, args)
+
+		children := make([]*CallNode, len(n.Children))
+		copy(children, n.Children)
+		sort.SliceStable(children, func(i, j int) bool {
+			return children[i].Inclusive.WallTime > children[j].Inclusive.WallTime
+		})
+
+		childTs := ts
+		for _, c := range children {
+			layout(c, childTs, ts+dur)
+			childTs += math.Max(float64(c.Inclusive.WallTime), 0)
+			if childTs >= ts+dur {
+				break
+			}
+		}
+	}
+	layout(root, 0, math.Inf(1))
+
+	return json.NewEncoder(w).Encode(file)
+}
+
+// WriteChromeTraceTimeline writes the calls of the trace in the Chrome Trace
+// Event format in the order and at the time they were made, with the file and
+// line they were called from and the memory they used.
+func WriteChromeTraceTimeline(w io.Writer, t *Trace) error {
+	file := newChromeTraceFile("Xdebug trace", nil)
+
+	t.Root.Walk(func(c *TraceCall) {
+		args := map[string]interface{}{
+			"memory": c.MemoryEnd - c.MemoryStart,
+		}
+		if c.File != "" {
+			args["file"] = c.File
+		}
+		if c.Line > 0 {
+			args["line"] = c.Line
+		}
+		file.add(c.Name, c.Start, c.Duration(), args)
+	})
+
+	return json.NewEncoder(w).Encode(file)
+}
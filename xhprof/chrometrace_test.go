@@ -0,0 +1,100 @@
+package xhprof
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteChromeTrace(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 1000,
+				Count:    1,
+				CpuTime:  800,
+			},
+			"main()==>foo": &PairCall{
+				WallTime: 200,
+				Count:    2,
+			},
+			"main()==>bar": &PairCall{
+				WallTime: 500,
+				Count:    1,
+			},
+			"bar==>baz": &PairCall{
+				WallTime: 100,
+				Count:    10,
+			},
+		},
+		Meta: map[string]string{"url": "/checkout"},
+	}
+
+	var b bytes.Buffer
+	err := WriteChromeTrace(&b, m)
+	require.Nil(t, err)
+
+	var file chromeTraceFile
+	err = json.Unmarshal(b.Bytes(), &file)
+	require.Nil(t, err)
+
+	assert.Equal(t, "/checkout", file.OtherData["url"])
+	require.Len(t, file.TraceEvents, 6)
+	assert.Equal(t, "M", file.TraceEvents[0].Ph)
+	assert.Equal(t, "Stacked by inclusive time", file.TraceEvents[1].Args["name"])
+
+	events := file.TraceEvents[2:]
+	expected := []struct {
+		name    string
+		ts, dur float64
+	}{
+		{"main()", 0, 1000},
+		{"bar", 0, 500},
+		{"baz", 0, 100},
+		{"foo", 500, 200},
+	}
+	for i, e := range expected {
+		assert.Equal(t, "X", events[i].Ph)
+		assert.Equal(t, e.name, events[i].Name)
+		assert.Equal(t, e.ts, events[i].Ts, e.name)
+		assert.Equal(t, e.dur, events[i].Dur, e.name)
+	}
+
+	assert.Equal(t, float64(800), events[0].Args["cpu"])
+	assert.Equal(t, float64(300), events[0].Args["excl_wt"])
+	assert.Equal(t, float64(10), events[2].Args["count"])
+}
+
+func TestWriteChromeTraceTimeline(t *testing.T) {
+	fh, err := os.Open("testdata/simple.xt")
+	require.Nil(t, err)
+	defer fh.Close()
+
+	trace, err := ParseXdebugTraceTree(fh)
+	require.Nil(t, err)
+
+	var b bytes.Buffer
+	err = WriteChromeTraceTimeline(&b, trace)
+	require.Nil(t, err)
+
+	var file chromeTraceFile
+	err = json.Unmarshal(b.Bytes(), &file)
+	require.Nil(t, err)
+
+	names := []string{}
+	for _, e := range file.TraceEvents[2:] {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t, []string{"main()", "require::/var/www/lib.php", "fib", "fib", "strlen", "strlen"}, names)
+
+	fib := file.TraceEvents[4]
+	assert.InDelta(t, 200, fib.Ts, 0.001)
+	assert.InDelta(t, 200, fib.Dur, 0.001)
+	assert.Equal(t, "/var/www/index.php", fib.Args["file"])
+	assert.Equal(t, float64(5), fib.Args["line"])
+	assert.Equal(t, float64(400), fib.Args["memory"])
+}
@@ -0,0 +1,105 @@
+package xhprof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WriteCollapsed writes the call tree of the map in the collapsed stack format
+// used by flamegraph.pl, one line per stack with the exclusive value of field
+// (a PairCall field, e.g. WallTime) for the last frame, e.g.
+//
+//	main();foo;bar 200
+func WriteCollapsed(w io.Writer, m *PairCallMap, field string) error {
+	root, err := m.CallTree(exportThreshold)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	root.Walk(func(n *CallNode) {
+		if err != nil {
+			return
+		}
+
+		value := math.Floor(float64(n.Exclusive().GetFloat32Field(field)) + 0.5)
+		if value <= 0 {
+			return
+		}
+
+		_, err = fmt.Fprintf(bw, "%s %.0f\n", strings.Join(n.Stack(), ";"), value)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// ParseCollapsed reads collapsed stacks into a PairCallMap. The value of each
+// stack is added to the wall time of all edges on it, and the count of an edge
+// is the number of stacks it appears in. Stacks not starting with main() get a
+// main() frame prepended, and recursive frames are named like XHProf does
+// (e.g. foo@1).
+func ParseCollapsed(rd io.Reader) (*PairCallMap, error) {
+	m := NewPairCallMap()
+	main := m.NewPairCall("main()")
+	main.Count = 1
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		i := strings.LastIndexAny(text, " \t")
+		if i < 0 {
+			return nil, errors.New("Collapsed stack has no value: " + text)
+		}
+
+		value, err := strconv.ParseFloat(text[i+1:], 32)
+		if err != nil {
+			return nil, errors.New("Collapsed stack has an invalid value: " + text)
+		}
+
+		frames := strings.Split(strings.TrimSpace(text[:i]), ";")
+		if frames[0] != "main()" {
+			frames = append([]string{"main()"}, frames...)
+		}
+
+		depths := make(map[string]int)
+		parent := ""
+		for _, frame := range frames {
+			name := frame
+			if depth := depths[frame]; depth > 0 {
+				name = fmt.Sprintf("%s@%d", frame, depth)
+			}
+			depths[frame]++
+
+			if parent != "" {
+				pc := m.NewPairCall(pairName(parent, name))
+				pc.Count++
+				pc.WallTime += float32(value)
+			}
+
+			parent = name
+		}
+
+		main.WallTime += float32(value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,106 @@
+package xhprof
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCollapsed(t *testing.T) {
+	expected := "main() 500\nmain();foo 300\nmain();foo;bar 200\n"
+
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 1000,
+				Count:    1,
+				CpuTime:  400,
+			},
+			"main()==>foo": &PairCall{
+				WallTime: 500,
+				Count:    2,
+				CpuTime:  400,
+			},
+			"foo==>bar": &PairCall{
+				WallTime: 200,
+				Count:    10,
+				CpuTime:  100,
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	err := WriteCollapsed(&b, m, "WallTime")
+	require.Nil(t, err)
+	assert.Equal(t, expected, b.String())
+
+	b.Reset()
+	err = WriteCollapsed(&b, m, "CpuTime")
+	require.Nil(t, err)
+	assert.Equal(t, "main();foo 300\nmain();foo;bar 100\n", b.String())
+}
+
+func TestParseCollapsed(t *testing.T) {
+	expected := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 1050,
+				Count:    1,
+			},
+			"main()==>foo": &PairCall{
+				WallTime: 550,
+				Count:    3,
+			},
+			"foo==>bar": &PairCall{
+				WallTime: 250,
+				Count:    2,
+			},
+			"bar==>foo@1": &PairCall{
+				WallTime: 50,
+				Count:    1,
+			},
+			"foo@1==>bar@1": &PairCall{
+				WallTime: 50,
+				Count:    1,
+			},
+		},
+	}
+
+	f, err := os.Open("testdata/simple.collapsed")
+	require.Nil(t, err)
+	defer f.Close()
+
+	m, err := ParseCollapsed(f)
+	require.Nil(t, err)
+	assert.EqualValues(t, expected, m)
+}
+
+func TestParseCollapsedWithoutMain(t *testing.T) {
+	expected := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 7,
+				Count:    1,
+			},
+			"main()==>index.php": &PairCall{
+				WallTime: 7,
+				Count:    2,
+			},
+			"index.php==>sleep": &PairCall{
+				WallTime: 4,
+				Count:    1,
+			},
+		},
+	}
+
+	m, err := ParseCollapsed(strings.NewReader("index.php;sleep 4\nindex.php 3\n"))
+	require.Nil(t, err)
+	assert.EqualValues(t, expected, m)
+
+	_, err = ParseCollapsed(strings.NewReader("index.php;sleep\n"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+package xhprof
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+
+	// bzip2BlockMagic starts the first block of a bzip2 stream, and
+	// bzip2EndMagic the end of a stream without blocks.
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+type writeCloser struct {
+	io.Writer
+	close func() error
+}
+
+func (w *writeCloser) Close() error {
+	return w.close()
+}
+
+// OpenFile opens the file at path for reading. Files compressed with gzip,
+// zstd or bzip2 are detected by their magic bytes and decompressed on the fly.
+func OpenFile(path string) (io.ReadCloser, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err := Decompress(fh)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	return &readCloser{Reader: rd, close: func() error {
+		if c, ok := rd.(io.Closer); ok {
+			c.Close()
+		}
+
+		return fh.Close()
+	}}, nil
+}
+
+// Decompress returns a reader with the decompressed contents of rd, if it is
+// compressed with gzip, zstd or bzip2, and the contents of rd otherwise.
+func Decompress(rd io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(rd)
+	magic, err := br.Peek(10)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+
+		return zr.IOReadCloser(), nil
+	case isBzip2(magic):
+		return bzip2.NewReader(br), nil
+	}
+
+	return br, nil
+}
+
+// isBzip2 returns whether magic is the start of a bzip2 stream: "BZh", the
+// block size from 1 to 9 and the magic of a block or of the end of the stream,
+// so that text starting with "BZh" is not mistaken for it.
+func isBzip2(magic []byte) bool {
+	if len(magic) < 10 || !bytes.HasPrefix(magic, bzip2Magic) || magic[3] < '1' || magic[3] > '9' {
+		return false
+	}
+
+	return bytes.Equal(magic[4:10], bzip2BlockMagic) || bytes.Equal(magic[4:10], bzip2EndMagic)
+}
+
+// CreateFile creates the file at path for writing, compressing it with gzip
+// if path ends in .gz.
+func CreateFile(path string) (io.WriteCloser, error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		return fh, nil
+	}
+
+	zw := gzip.NewWriter(fh)
+	return &writeCloser{Writer: zw, close: func() error {
+		if err := zw.Close(); err != nil {
+			fh.Close()
+			return err
+		}
+
+		return fh.Close()
+	}}, nil
+}
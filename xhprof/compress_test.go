@@ -0,0 +1,97 @@
+package xhprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecompress(t *testing.T) {
+	data := []byte(`{"main()":{"ct":1,"wt":1000}}`)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+
+	var zst bytes.Buffer
+	enc, err := zstd.NewWriter(&zst)
+	require.Nil(t, err)
+	enc.Write(data)
+	enc.Close()
+
+	for name, input := range map[string][]byte{"plain": data, "gzip": gz.Bytes(), "zstd": zst.Bytes()} {
+		rd, err := Decompress(bytes.NewReader(input))
+		require.Nil(t, err, name)
+
+		out, err := ioutil.ReadAll(rd)
+		require.Nil(t, err, name)
+		assert.Equal(t, data, out, name)
+	}
+
+	rd, err := Decompress(bytes.NewReader(nil))
+	require.Nil(t, err)
+	out, err := ioutil.ReadAll(rd)
+	require.Nil(t, err)
+	assert.Len(t, out, 0)
+}
+
+func TestOpenFileBzip2(t *testing.T) {
+	expected, err := ioutil.ReadFile("testdata/simple.xhprof")
+	require.Nil(t, err)
+
+	rd, err := OpenFile("testdata/simple.xhprof.bz2")
+	require.Nil(t, err)
+	defer rd.Close()
+
+	out, err := ioutil.ReadAll(rd)
+	require.Nil(t, err)
+	assert.Equal(t, expected, out)
+}
+
+func TestDecompressBzip2Detection(t *testing.T) {
+	compressed, err := ioutil.ReadFile("testdata/simple.xhprof.bz2")
+	require.Nil(t, err)
+	assert.True(t, isBzip2(compressed[:10]))
+
+	// An empty stream has no blocks.
+	assert.True(t, isBzip2(append([]byte("BZh9"), bzip2EndMagic...)))
+
+	for _, text := range []string{"BZh", "BZh9", "BZhello world", "BZh91AY&SX", "BZh01AY&SY"} {
+		assert.False(t, isBzip2([]byte(text)), text)
+
+		rd, err := Decompress(bytes.NewReader([]byte(text)))
+		require.Nil(t, err, text)
+
+		out, err := ioutil.ReadAll(rd)
+		require.Nil(t, err, text)
+		assert.Equal(t, text, string(out))
+	}
+}
+
+func TestCreateFileGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolkit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "profile.xhprof.gz")
+	f := NewFile(path, "xhprof")
+	m, err := NewFile("testdata/simple.xhprof", "xhprof").GetPairCallMap()
+	require.Nil(t, err)
+	require.Nil(t, f.WritePairCallMap(m))
+
+	raw, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, gzipMagic, raw[:2])
+
+	parsed, err := f.GetPairCallMap()
+	require.Nil(t, err)
+	assert.EqualValues(t, m, parsed)
+}
@@ -0,0 +1,78 @@
+package xhprof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// detectSize is the number of bytes looked at to detect the format of a file.
+const detectSize = 4096
+
+var (
+	serializedPattern = regexp.MustCompile(`^a:\d+:\{`)
+	collapsedPattern  = regexp.MustCompile(`^\S[^\n]*\s\d+(?:\.\d+)?\r?$`)
+	xhguiPattern      = regexp.MustCompile(`"(?:profile|meta|_id)"\s*:`)
+	xdebugPattern     = regexp.MustCompile(`^(?:Version: \S+\r?\nFile format: \d|TRACE START )`)
+	callgrindHeaders  = [][]byte{
+		[]byte("# callgrind format"),
+		[]byte("version:"),
+		[]byte("creator:"),
+		[]byte("cmd:"),
+		[]byte("events:"),
+		[]byte("positions:"),
+	}
+)
+
+// DetectFormat returns the format of the profile at the start of rd, one of
+// xhprof, xhgui, callgrind, xdebug-trace, serialized or collapsed, without
+// consuming it.
+func DetectFormat(rd *bufio.Reader) (string, error) {
+	data, err := rd.Peek(detectSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) == 0 {
+		return "", errors.New("Could not detect the format of an empty profile")
+	}
+
+	if data[0] == '[' {
+		return "xhgui", nil
+	}
+
+	if data[0] == '{' {
+		if xhguiPattern.Match(data) {
+			return "xhgui", nil
+		}
+
+		return "xhprof", nil
+	}
+
+	if xdebugPattern.Match(data) {
+		return "xdebug-trace", nil
+	}
+
+	for _, header := range callgrindHeaders {
+		if bytes.HasPrefix(data, header) {
+			return "callgrind", nil
+		}
+	}
+
+	if serializedPattern.Match(data) {
+		return "serialized", nil
+	}
+
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	if collapsedPattern.Match(line) {
+		return "collapsed", nil
+	}
+
+	return "", errors.New("Could not detect the format of the profile")
+}
@@ -0,0 +1,48 @@
+package xhprof
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		`{"main()":{"ct":1,"wt":10}}`:                      "xhprof",
+		"\n  {\"main()\":{}}":                              "xhprof",
+		"# callgrind format\nevents: Time\n":               "callgrind",
+		`{"_id":{"$oid":"5b1e"},"meta":{},"profile":{}}`:   "xhgui",
+		`[{"profile":{}}]`:                                 "xhgui",
+		"version: 1\ncreator: xdebug 2.5.5\n":              "callgrind",
+		"events: Ir\nfn=main\n1 2\n":                       "callgrind",
+		`a:2:{s:6:"main()";a:2:{s:2:"ct";i:1;}}`:           "serialized",
+		"main();foo;bar 200\nmain();foo 100\n":             "collapsed",
+		"foo 12.5\r\n":                                     "collapsed",
+		"main() 1\n":                                       "collapsed",
+		"App\\Kernel::handle;PDO::query 34\n":              "collapsed",
+		"# callgrind format\r\nversion: 1\r\n":             "callgrind",
+		"version: 1\n" + strings.Repeat("x", 2*detectSize): "callgrind",
+		"Version: 3.1.0\nFile format: 4\nTRACE START [":    "xdebug-trace",
+		"TRACE START [2020-01-01 10:00:00.000000]\n":       "xdebug-trace",
+	}
+
+	for data, expected := range cases {
+		rd := bufio.NewReaderSize(strings.NewReader(data), detectSize)
+		format, err := DetectFormat(rd)
+		require.Nil(t, err, data)
+		assert.Equal(t, expected, format, data)
+
+		// The detection must not consume any data
+		first, err := rd.ReadByte()
+		require.Nil(t, err)
+		assert.Equal(t, data[0], first)
+	}
+
+	for _, data := range []string{"", "  \n", "hello world\n", "<?php echo 1;"} {
+		_, err := DetectFormat(bufio.NewReader(strings.NewReader(data)))
+		assert.NotNil(t, err, data)
+	}
+}
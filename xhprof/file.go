@@ -1,14 +1,22 @@
 package xhprof
 
 import (
+	"bufio"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 )
 
 type File struct {
 	Path   string
 	Format string
+
+	// Dimensions are the PairCall fields written to formats with one
+	// profile per dimension, like speedscope, and default to the wall time,
+	// CPU time and memory.
+	Dimensions []string
 }
 
 func NewFile(path, format string) (f *File) {
@@ -28,34 +36,195 @@ func (f *File) GetProfile() (*Profile, error) {
 	return m.Flatten(), nil
 }
 
-func (f *File) GetPairCallMap() (m *PairCallMap, err error) {
-	if f.Format == "callgrind" {
-		fh, err := os.Open(f.Path)
+func (f *File) GetPairCallMap() (*PairCallMap, error) {
+	rd, closer, format, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	return parsePairCallMap(rd, format)
+}
+
+// GetPairCallMaps returns a PairCallMap for each part of callgrind files and
+// for each run of XHGui exports, and the single PairCallMap of the file for
+// all other formats.
+func (f *File) GetPairCallMaps() ([]*PairCallMap, error) {
+	rd, closer, format, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	switch format {
+	case "callgrind":
+		return ParseCallgrindParts(rd)
+	case "xhgui":
+		return ParseXhguiRuns(rd)
+	}
+
+	m, err := parsePairCallMap(rd, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*PairCallMap{m}, nil
+}
+
+// GetTrace returns the ordered call tree of function traces, which is not
+// available for any other format.
+func (f *File) GetTrace() (*Trace, error) {
+	rd, closer, format, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	if format != "xdebug-trace" {
+		return nil, fmt.Errorf("%s: %s profiles have no call order", f.Path, format)
+	}
+
+	return ParseXdebugTraceTree(rd)
+}
+
+func parsePairCallMap(rd io.Reader, format string) (*PairCallMap, error) {
+	var parse func(io.Reader) (*PairCallMap, error)
+	switch format {
+	case "xhprof":
+		parse = ParseXhprof
+	case "callgrind":
+		parse = ParseCallgrind
+	case "collapsed":
+		parse = ParseCollapsed
+	case "serialized":
+		parse = ParseSerialized
+	case "xhgui":
+		parse = ParseXhgui
+	case "xdebug-trace":
+		parse = ParseXdebugTrace
+	default:
+		return nil, errors.New("Unsupported input format: " + format)
+	}
+
+	return parse(rd)
+}
+
+// DetectFormat returns the format of the file, detected from its contents if
+// it is "auto".
+func (f *File) DetectFormat() (string, error) {
+	_, closer, format, err := f.open()
+	if err != nil {
+		return "", err
+	}
+	closer.Close()
+
+	return format, nil
+}
+
+// open opens the file for reading and detects its format, if it is "auto".
+func (f *File) open() (*bufio.Reader, io.Closer, string, error) {
+	fh, err := OpenFile(f.Path)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	rd := bufio.NewReaderSize(fh, detectSize)
+	format := f.Format
+	if format == "auto" {
+		format, err = DetectFormat(rd)
 		if err != nil {
-			return nil, err
+			fh.Close()
+			return nil, nil, "", fmt.Errorf("%s: %s", f.Path, err)
+		}
+	}
+
+	return rd, fh, format, nil
+}
+
+func (f *File) WritePairCallMap(m *PairCallMap) error {
+	var write func(io.Writer, *PairCallMap) error
+	switch f.Format {
+	case "xhprof":
+		write = WriteXhprof
+	case "collapsed":
+		write = func(w io.Writer, m *PairCallMap) error {
+			return WriteCollapsed(w, m, "WallTime")
+		}
+	case "callgrind":
+		write = WriteCallgrind
+	case "pprof":
+		write = WritePprof
+	case "speedscope":
+		write = func(w io.Writer, m *PairCallMap) error {
+			dimensions := f.Dimensions
+			if len(dimensions) == 0 {
+				dimensions = []string{"WallTime", "CpuTime", "Memory"}
+			}
+
+			return WriteSpeedscope(w, m, dimensions)
 		}
+	case "chrome":
+		write = WriteChromeTrace
+	case "xhgui":
+		write = WriteXhgui
+	default:
+		return errors.New("Unsupported output format: " + f.Format)
+	}
 
-		return ParseCallgrind(fh)
+	return f.write(func(w io.Writer) error {
+		return write(w, m)
+	})
+}
+
+// WriteTrace writes the calls of the trace in the order they were made, which
+// only the chrome format supports.
+func (f *File) WriteTrace(t *Trace) error {
+	if f.Format != "chrome" {
+		return errors.New("Unsupported output format for traces: " + f.Format)
+	}
+
+	return f.write(func(w io.Writer) error {
+		return WriteChromeTraceTimeline(w, t)
+	})
+}
+
+func (f *File) write(write func(io.Writer) error) error {
+	// pprof profiles are always compressed with gzip.
+	var fh io.WriteCloser
+	var err error
+	if f.Format == "pprof" {
+		fh, err = os.Create(f.Path)
+	} else {
+		fh, err = CreateFile(f.Path)
+	}
+	if err != nil {
+		return err
 	}
 
-	var rawData []byte
-	if rawData, err = ioutil.ReadFile(f.Path); err != nil {
-		return
+	if err = write(fh); err != nil {
+		fh.Close()
+		return err
 	}
 
-	m = new(PairCallMap)
-	if err = json.Unmarshal(rawData, &m.M); err != nil {
-		return
+	return fh.Close()
+}
+
+func ParseXhprof(rd io.Reader) (*PairCallMap, error) {
+	m := new(PairCallMap)
+	if err := json.NewDecoder(rd).Decode(&m.M); err != nil {
+		return nil, err
 	}
 
-	return
+	return m, nil
 }
 
-func (f *File) WritePairCallMap(m *PairCallMap) error {
+func WriteXhprof(w io.Writer, m *PairCallMap) error {
 	data, err := json.Marshal(m.M)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(f.Path, data, 0755)
+	_, err = w.Write(data)
+
+	return err
 }
@@ -36,3 +36,53 @@ func TestGetPairCallMap(t *testing.T) {
 	require.Nil(t, err)
 	assert.EqualValues(t, expected, m)
 }
+
+func TestGetPairCallMapCollapsed(t *testing.T) {
+	f := NewFile("testdata/simple.collapsed", "collapsed")
+	m, err := f.GetPairCallMap()
+	require.Nil(t, err)
+	assert.Equal(t, float32(1050), m.M["main()"].WallTime)
+
+	f = NewFile("testdata/simple.collapsed", "unknown")
+	_, err = f.GetPairCallMap()
+	assert.NotNil(t, err)
+}
+
+func TestGetPairCallMapAuto(t *testing.T) {
+	for _, path := range []string{"testdata/simple.xhprof", "testdata/simple.xhprof.bz2", "testdata/callgrind-simple.out", "testdata/simple.collapsed", "testdata/simple.xt"} {
+		f := NewFile(path, "auto")
+		m, err := f.GetPairCallMap()
+		require.Nil(t, err, path)
+		assert.Contains(t, m.M, "main()", path)
+
+		maps, err := f.GetPairCallMaps()
+		require.Nil(t, err, path)
+		assert.Len(t, maps, 1, path)
+	}
+
+	f := NewFile("testdata/callgrind-parts.out", "auto")
+	maps, err := f.GetPairCallMaps()
+	require.Nil(t, err)
+	assert.Len(t, maps, 2)
+}
+
+func TestGetTrace(t *testing.T) {
+	f := NewFile("testdata/simple.xt", "auto")
+	trace, err := f.GetTrace()
+	require.Nil(t, err)
+	assert.Equal(t, "main()", trace.Root.Name)
+
+	f = NewFile("testdata/simple.xhprof", "auto")
+	_, err = f.GetTrace()
+	assert.NotNil(t, err)
+}
+
+func TestFileDetectFormat(t *testing.T) {
+	format, err := NewFile("testdata/simple.xt", "auto").DetectFormat()
+	require.Nil(t, err)
+	assert.Equal(t, "xdebug-trace", format)
+
+	format, err = NewFile("testdata/simple.xt", "collapsed").DetectFormat()
+	require.Nil(t, err)
+	assert.Equal(t, "collapsed", format)
+}
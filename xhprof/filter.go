@@ -0,0 +1,417 @@
+package xhprof
+
+import (
+	"math"
+	"regexp"
+	"sort"
+)
+
+// Include keeps only the functions matching re and main(), like the show
+// option of pprof. The costs of other functions are attributed to their
+// nearest caller that is kept, and the functions they call are attached to
+// it.
+func (m *PairCallMap) Include(re *regexp.Regexp) *PairCallMap {
+	return m.hide(func(fn string) bool { return !re.MatchString(fn) })
+}
+
+// Exclude removes the functions matching re, like the hide option of pprof.
+// Their costs are attributed to their callers, and the functions they call
+// are attached to them. main() is never removed.
+func (m *PairCallMap) Exclude(re *regexp.Regexp) *PairCallMap {
+	return m.hide(re.MatchString)
+}
+
+// Focus keeps only the calls into functions matching re, the calls below
+// them and the calls on the paths leading to them, like the focus option of
+// pprof. Functions that are also called from elsewhere keep the share of their
+// costs that stems from the focused calls, and their callers the share of
+// their costs that reaches the focused functions, so that main() holds the
+// total of the focused costs.
+func (m *PairCallMap) Focus(re *regexp.Regexp) *PairCallMap {
+	g := newCallGraph(m)
+
+	fixed := map[string]float64{"": 0}
+	for _, fn := range g.functions() {
+		if re.MatchString(fn) {
+			fixed[fn] = 1
+		}
+	}
+
+	below := g.keptFractions(g.callerShares(), fixed)
+	reaching := g.keptFractions(g.calleeShares(), fixed)
+
+	r := newCallGraph(NewPairCallMap())
+	for parent, children := range g.children {
+		for child, pc := range children {
+			if f := below[parent] + (1-below[parent])*reaching[child]; f > 0 {
+				r.add(parent, child, pc, f)
+			}
+		}
+	}
+
+	// main() is still called once per request.
+	if main, ok := g.children[""]["main()"]; ok {
+		r.add("", "main()", new(PairCall), 1)
+		r.counts[r.children[""]["main()"]] = g.count(main)
+	}
+
+	return r.pairCallMap(m)
+}
+
+// Ignore removes the functions matching re along with the calls below them,
+// like the ignore option of pprof, except that their costs are attributed to
+// their callers rather than dropped. Functions that are also called from
+// elsewhere keep the share of their costs that stems from these calls. main()
+// is never removed.
+func (m *PairCallMap) Ignore(re *regexp.Regexp) *PairCallMap {
+	g := newCallGraph(m)
+
+	fixed := map[string]float64{"": 1}
+	for _, fn := range g.functions() {
+		if fn != "main()" && re.MatchString(fn) {
+			fixed[fn] = 0
+		}
+	}
+
+	kept := g.keptFractions(g.callerShares(), fixed)
+	r := newCallGraph(NewPairCallMap())
+	for parent, children := range g.children {
+		for child, pc := range children {
+			if _, ignored := fixed[child]; ignored || kept[parent] <= 0 {
+				continue
+			}
+
+			r.add(parent, child, pc, kept[parent])
+		}
+	}
+
+	return r.pairCallMap(m)
+}
+
+// hide removes the functions for which hidden returns true, except main(),
+// one after another.
+func (m *PairCallMap) hide(hidden func(fn string) bool) *PairCallMap {
+	g := newCallGraph(m)
+	for _, fn := range g.functions() {
+		if fn != "main()" && hidden(fn) {
+			g.hide(fn)
+		}
+	}
+
+	return g.pairCallMap(m)
+}
+
+// callGraph indexes the pair calls of a map by caller and by callee, with ""
+// as the caller of main(). The numbers of calls are kept exactly in counts
+// while calls are split up, and only rounded by pairCallMap.
+type callGraph struct {
+	children map[string]map[string]*PairCall
+	parents  map[string]map[string]*PairCall
+	counts   map[*PairCall]float64
+}
+
+func newCallGraph(m *PairCallMap) *callGraph {
+	g := &callGraph{
+		children: make(map[string]map[string]*PairCall),
+		parents:  make(map[string]map[string]*PairCall),
+		counts:   make(map[*PairCall]float64),
+	}
+
+	for name, pc := range m.M {
+		parent, child := parsePairName(name)
+		g.add(parent, child, pc, 1)
+	}
+
+	return g
+}
+
+// add adds the costs of pc multiplied by f to the call from parent to child.
+func (g *callGraph) add(parent, child string, pc *PairCall, f float64) {
+	if _, ok := g.children[parent]; !ok {
+		g.children[parent] = make(map[string]*PairCall)
+	}
+	if _, ok := g.parents[child]; !ok {
+		g.parents[child] = make(map[string]*PairCall)
+	}
+
+	call, ok := g.children[parent][child]
+	if !ok {
+		call = new(PairCall)
+		g.children[parent][child] = call
+		g.parents[child][parent] = call
+	}
+
+	call.Add(scalePairCall(pc, f))
+	g.counts[call] += g.count(pc) * f
+}
+
+func (g *callGraph) remove(parent, child string) {
+	delete(g.counts, g.children[parent][child])
+	delete(g.children[parent], child)
+	delete(g.parents[child], parent)
+}
+
+// count returns the exact number of calls of pc, which may be a call of
+// another graph or map.
+func (g *callGraph) count(pc *PairCall) float64 {
+	if n, ok := g.counts[pc]; ok {
+		return n
+	}
+
+	return float64(pc.Count)
+}
+
+// functions returns the names of all called functions, sorted.
+func (g *callGraph) functions() []string {
+	fns := make([]string, 0, len(g.parents))
+	for fn := range g.parents {
+		fns = append(fns, fn)
+	}
+	sort.Strings(fns)
+
+	return fns
+}
+
+// hide removes fn, attaching the functions it calls to its callers in
+// proportion to the calls of each caller into fn.
+func (g *callGraph) hide(fn string) {
+	g.remove(fn, fn)
+
+	parents := g.parents[fn]
+	children := g.children[fn]
+
+	shares := callShares(parents)
+	if len(shares) == 0 {
+		shares[""] = 1
+	}
+
+	for parent, share := range shares {
+		for child, pc := range children {
+			if parent != child {
+				g.add(parent, child, pc, share)
+			}
+		}
+	}
+
+	for parent := range parents {
+		g.remove(parent, fn)
+	}
+	for child := range children {
+		g.remove(fn, child)
+	}
+
+	delete(g.parents, fn)
+	delete(g.children, fn)
+}
+
+// keptFractions returns the fraction of the costs of each function that is
+// kept, given the fixed fractions of some functions and of "", the caller of
+// main(). The fraction of any other function with shares is the sum of the
+// fractions of the functions it is related to, weighted by their shares, like
+// those of callerShares or calleeShares, which is solved iteratively to
+// converge for recursive calls, too. Functions without shares and not fixed
+// keep nothing.
+func (g *callGraph) keptFractions(shares map[string]map[string]float64, fixed map[string]float64) map[string]float64 {
+	fns := make([]string, 0, len(shares))
+	for fn := range shares {
+		if _, ok := fixed[fn]; !ok {
+			fns = append(fns, fn)
+		}
+	}
+	sort.Strings(fns)
+
+	index := make(map[string]int, len(fns))
+	for i, fn := range fns {
+		index[fn] = i
+	}
+
+	// The fixed fractions are added up once, the others are looked up by
+	// index while iterating.
+	type relation struct {
+		index int
+		share float64
+	}
+
+	constant := make([]float64, len(fns))
+	relations := make([][]relation, len(fns))
+	for i, fn := range fns {
+		for related, share := range shares[fn] {
+			if f, ok := fixed[related]; ok {
+				constant[i] += f * share
+			} else if j, ok := index[related]; ok {
+				relations[i] = append(relations[i], relation{j, share})
+			}
+		}
+	}
+
+	fractions := make([]float64, len(fns))
+	for n := 0; n < len(fns)+100; n++ {
+		changed := false
+		for i := range fns {
+			f := constant[i]
+			for _, r := range relations[i] {
+				f += fractions[r.index] * r.share
+			}
+
+			if math.Abs(f-fractions[i]) > 1e-9 {
+				changed = true
+			}
+			fractions[i] = f
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	kept := make(map[string]float64, len(fns)+len(fixed))
+	for fn, f := range fixed {
+		kept[fn] = f
+	}
+	for i, fn := range fns {
+		kept[fn] = fractions[i]
+	}
+
+	return kept
+}
+
+// callerShares returns the share of the calls into each function that comes
+// from each of its callers, so that the fraction of a function is the share
+// of its costs that stems from kept callers.
+func (g *callGraph) callerShares() map[string]map[string]float64 {
+	shares := make(map[string]map[string]float64, len(g.parents))
+	for fn, parents := range g.parents {
+		shares[fn] = callShares(parents)
+	}
+
+	return shares
+}
+
+// calleeShares returns the share of the inclusive wall time of each function
+// that is spent in each of the functions it calls, so that the fraction of a
+// function is the share of its costs that reaches kept functions. Functions
+// without wall time share their calls like callShares.
+func (g *callGraph) calleeShares() map[string]map[string]float64 {
+	shares := make(map[string]map[string]float64, len(g.parents))
+	for fn, parents := range g.parents {
+		children := g.children[fn]
+
+		var inclusive, called float64
+		for parent, pc := range parents {
+			if parent != fn {
+				inclusive += float64(pc.WallTime)
+			}
+		}
+		for child, pc := range children {
+			if child != fn {
+				called += float64(pc.WallTime)
+			}
+		}
+
+		if called > inclusive {
+			inclusive = called
+		}
+
+		if inclusive <= 0 {
+			shares[fn] = callShares(children)
+			continue
+		}
+
+		shares[fn] = make(map[string]float64, len(children))
+		for child, pc := range children {
+			if child != fn {
+				shares[fn][child] = float64(pc.WallTime) / inclusive
+			}
+		}
+	}
+
+	return shares
+}
+
+// pairCallMap returns the calls of the graph as a map, with the sources and
+// meta data of m.
+func (g *callGraph) pairCallMap(m *PairCallMap) *PairCallMap {
+	r := NewPairCallMap()
+	r.Sources = m.Sources
+	r.Meta = m.Meta
+
+	for child, parents := range g.parents {
+		g.roundCounts(parents)
+		for parent, pc := range parents {
+			r.M[pairName(parent, child)] = pc
+		}
+	}
+
+	return r
+}
+
+// roundCounts rounds the exact numbers of the calls into a function, so that
+// they add up to its rounded total number of calls. The calls with the
+// largest remainders get the calls left over from rounding down.
+func (g *callGraph) roundCounts(calls map[string]*PairCall) {
+	names := make([]string, 0, len(calls))
+	var total float64
+	for name, pc := range calls {
+		names = append(names, name)
+		total += g.count(pc)
+	}
+	sort.Strings(names)
+
+	left := int(math.Round(total))
+	for _, name := range names {
+		calls[name].Count = int(math.Floor(g.count(calls[name])))
+		left -= calls[name].Count
+	}
+
+	remainder := func(name string) float64 {
+		n := g.count(calls[name])
+		return n - math.Floor(n)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return remainder(names[i]) > remainder(names[j])
+	})
+
+	for i := 0; i < left && i < len(names); i++ {
+		calls[names[i]].Count++
+	}
+}
+
+// callShares returns the share of each of the calls by wall time, or by
+// number of calls for profiles without wall time.
+func callShares(calls map[string]*PairCall) map[string]float64 {
+	var wallTime float64
+	var count int
+	for _, pc := range calls {
+		wallTime += float64(pc.WallTime)
+		count += pc.Count
+	}
+
+	shares := make(map[string]float64, len(calls))
+	for name, pc := range calls {
+		switch {
+		case wallTime > 0:
+			shares[name] = float64(pc.WallTime) / wallTime
+		case count > 0:
+			shares[name] = float64(pc.Count) / float64(count)
+		default:
+			shares[name] = 1 / float64(len(calls))
+		}
+	}
+
+	return shares
+}
+
+// scalePairCall returns a copy of pc with all costs multiplied by f, and the
+// number of calls rounded.
+func scalePairCall(pc *PairCall, f float64) *PairCall {
+	if f == 1 {
+		return pc.Copy()
+	}
+
+	r := new(PairCall)
+	aggregateFields(r, []interface{}{pc}, func(values []float64) float64 {
+		return values[0] * f
+	})
+
+	return r
+}
@@ -0,0 +1,209 @@
+package xhprof
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFilterTestMap() *PairCallMap {
+	return &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":     &PairCall{Count: 1, WallTime: 1000},
+			"main()==>a": &PairCall{Count: 1, WallTime: 600},
+			"main()==>b": &PairCall{Count: 1, WallTime: 300},
+			"a==>c":      &PairCall{Count: 2, WallTime: 400},
+			"b==>c":      &PairCall{Count: 1, WallTime: 100},
+			"c==>d":      &PairCall{Count: 3, WallTime: 250},
+		},
+		Meta: map[string]string{"url": "/"},
+	}
+}
+
+// wallTimes returns the inclusive and exclusive wall time of each function.
+func wallTimes(m *PairCallMap) map[string][2]float32 {
+	r := make(map[string][2]float32)
+	for _, c := range m.Flatten().Calls {
+		r[c.Name] = [2]float32{c.WallTime, c.ExclusiveWallTime}
+	}
+
+	return r
+}
+
+func TestPairCallMapExclude(t *testing.T) {
+	m := newFilterTestMap()
+	filtered := m.Exclude(regexp.MustCompile(`^c$`))
+
+	assert.Equal(t, m.Meta, filtered.Meta)
+	assert.Equal(t, map[string][2]float32{
+		"main()": {1000, 100},
+		"a":      {600, 400},
+		"b":      {300, 250},
+		"d":      {250, 250},
+	}, wallTimes(filtered))
+	assert.Equal(t, 2, filtered.M["a==>d"].Count)
+	assert.Equal(t, 1, filtered.M["b==>d"].Count)
+
+	// The original map is left as it is.
+	assert.Equal(t, float32(400), m.M["a==>c"].WallTime)
+	assert.Len(t, m.M, 6)
+
+	assert.Equal(t, wallTimes(m), wallTimes(m.Exclude(regexp.MustCompile(`^main`))))
+}
+
+func TestPairCallMapInclude(t *testing.T) {
+	filtered := newFilterTestMap().Include(regexp.MustCompile(`^(a|d)$`))
+
+	assert.Equal(t, map[string][2]float32{
+		"main()": {1000, 350},
+		"a":      {600, 400},
+		"d":      {250, 250},
+	}, wallTimes(filtered))
+}
+
+func TestPairCallMapIgnore(t *testing.T) {
+	m := newFilterTestMap()
+
+	assert.Equal(t, map[string][2]float32{
+		"main()": {1000, 100},
+		"a":      {600, 600},
+		"b":      {300, 300},
+	}, wallTimes(m.Ignore(regexp.MustCompile(`^c$`))))
+
+	// c is called from b as well, which keeps its share of c and d.
+	assert.Equal(t, map[string][2]float32{
+		"main()": {1000, 700},
+		"b":      {300, 200},
+		"c":      {100, 50},
+		"d":      {50, 50},
+	}, wallTimes(m.Ignore(regexp.MustCompile(`^a$`))))
+
+	assert.Equal(t, wallTimes(m), wallTimes(m.Ignore(regexp.MustCompile(`^main`))))
+}
+
+func TestPairCallMapFocus(t *testing.T) {
+	m := newFilterTestMap()
+
+	filtered := m.Focus(regexp.MustCompile(`^c$`))
+	assert.Equal(t, map[string][2]float32{
+		"main()": {500, 0},
+		"a":      {400, 0},
+		"b":      {100, 0},
+		"c":      {500, 250},
+		"d":      {250, 250},
+	}, wallTimes(filtered))
+	assert.Equal(t, 1, filtered.M["main()"].Count)
+	assert.Equal(t, 2, filtered.M["a==>c"].Count)
+
+	// c is called from a as well, which takes its share of c and d.
+	assert.Equal(t, map[string][2]float32{
+		"main()": {300, 0},
+		"b":      {300, 200},
+		"c":      {100, 50},
+		"d":      {50, 50},
+	}, wallTimes(m.Focus(regexp.MustCompile(`^b$`))))
+
+	assert.Equal(t, wallTimes(m), wallTimes(m.Focus(regexp.MustCompile(`^main`))))
+
+	assert.Equal(t, map[string][2]float32{
+		"main()": {0, 0},
+	}, wallTimes(m.Focus(regexp.MustCompile(`^x$`))))
+}
+
+func TestPairCallMapFocusCallers(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":        &PairCall{Count: 1, WallTime: 100},
+			"main()==>ctrl": &PairCall{Count: 1, WallTime: 90},
+			"ctrl==>db":     &PairCall{Count: 2, WallTime: 30},
+			"ctrl==>tpl":    &PairCall{Count: 1, WallTime: 40},
+			"tpl==>db":      &PairCall{Count: 1, WallTime: 20},
+			"db==>pdo":      &PairCall{Count: 3, WallTime: 25},
+		},
+	}
+
+	// tpl spends half of its time in db, and ctrl the 30 of its own calls
+	// and the 20 of tpl.
+	filtered := m.Focus(regexp.MustCompile(`^db$`))
+	assert.Equal(t, map[string][2]float32{
+		"main()": {50, 0},
+		"ctrl":   {50, 0},
+		"tpl":    {20, 0},
+		"db":     {50, 25},
+		"pdo":    {25, 25},
+	}, wallTimes(filtered))
+	assert.Equal(t, 2, filtered.M["ctrl==>db"].Count)
+	assert.Equal(t, 3, filtered.M["db==>pdo"].Count)
+}
+
+func TestPairCallMapFilterRecursion(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":     &PairCall{Count: 1, WallTime: 1000},
+			"main()==>a": &PairCall{Count: 1, WallTime: 800},
+			"a==>b":      &PairCall{Count: 1, WallTime: 600},
+			"b==>a":      &PairCall{Count: 1, WallTime: 400},
+		},
+	}
+
+	// The recursive call of a through b is not counted twice.
+	assert.Equal(t, map[string][2]float32{
+		"main()": {1000, 200},
+		"a":      {800, 800},
+	}, wallTimes(m.Exclude(regexp.MustCompile(`^b$`))))
+
+	ignored := wallTimes(m.Ignore(regexp.MustCompile(`^b$`)))
+	assert.Equal(t, [2]float32{800, 800}, ignored["a"])
+	assert.NotContains(t, ignored, "b")
+}
+
+func TestCallShares(t *testing.T) {
+	assert.Equal(t, map[string]float64{"a": 0.75, "b": 0.25}, callShares(map[string]*PairCall{
+		"a": &PairCall{Count: 1, WallTime: 300},
+		"b": &PairCall{Count: 3, WallTime: 100},
+	}))
+	assert.Equal(t, map[string]float64{"a": 0.25, "b": 0.75}, callShares(map[string]*PairCall{
+		"a": &PairCall{Count: 1},
+		"b": &PairCall{Count: 3},
+	}))
+	assert.Equal(t, map[string]float64{"a": 0.5, "b": 0.5}, callShares(map[string]*PairCall{
+		"a": &PairCall{},
+		"b": &PairCall{},
+	}))
+}
+
+func TestScalePairCall(t *testing.T) {
+	pc := &PairCall{Count: 3, WallTime: 25, Memory: 7, Costs: map[string]float32{"Ir": 5}}
+
+	assert.Equal(t, pc, scalePairCall(pc, 1))
+	assert.Equal(t, &PairCall{Count: 2, WallTime: 12.5, Memory: 3.5, Costs: map[string]float32{"Ir": 2.5}}, scalePairCall(pc, 0.5))
+
+	// Calls split up across callers keep their total number, which rounding
+	// each of the scaled calls would raise to 3 here.
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":      &PairCall{Count: 1, WallTime: 1000},
+			"main()==>p1": &PairCall{Count: 1, WallTime: 300},
+			"main()==>p2": &PairCall{Count: 1, WallTime: 300},
+			"main()==>p3": &PairCall{Count: 1, WallTime: 300},
+			"p1==>x":      &PairCall{Count: 1, WallTime: 100},
+			"p2==>x":      &PairCall{Count: 1, WallTime: 100},
+			"p3==>x":      &PairCall{Count: 1, WallTime: 100},
+			"x==>y":       &PairCall{Count: 2, WallTime: 60},
+		},
+	}
+
+	for _, filtered := range []*PairCallMap{
+		m.Exclude(regexp.MustCompile(`^x$`)),
+		m.Include(regexp.MustCompile(`^(main\(\)|y)$`)),
+	} {
+		count := 0
+		for name, pc := range filtered.M {
+			if _, child := parsePairName(name); child == "y" {
+				count += pc.Count
+			}
+		}
+		assert.Equal(t, 2, count)
+	}
+}
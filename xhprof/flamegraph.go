@@ -0,0 +1,236 @@
+package xhprof
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"math"
+)
+
+const (
+	flameGraphWidth       = 1200
+	flameGraphPadding     = 10
+	flameGraphFrameHeight = 16
+	flameGraphTop         = 60
+	flameGraphBottom      = 30
+	flameGraphCharWidth   = 7
+	flameGraphMinWidth    = 0.1
+)
+
+type flameFrame struct {
+	node  *CallNode
+	x     float64
+	w     float64
+	depth int
+}
+
+// GenerateFlameGraph renders the call tree of the map as a self-contained,
+// interactive SVG flame graph. The width of each frame is the inclusive value
+// of field (a PairCall field, e.g. WallTime), values are divided by divisor
+// and suffixed with unit in the tooltips. Subtrees with less than threshold
+// of main()'s wall time are left out, as are frames too narrow to be drawn.
+func GenerateFlameGraph(m *PairCallMap, field, title, unit string, divisor float32, threshold float32) (string, error) {
+	innerWidth := float64(flameGraphWidth - 2*flameGraphPadding)
+	if minThreshold := float32(flameGraphMinWidth / innerWidth); threshold < minThreshold {
+		threshold = minThreshold
+	}
+
+	root, err := m.CallTree(threshold)
+	if err != nil {
+		return "", err
+	}
+
+	total := flameValue(root, field)
+	if total <= 0 {
+		return "", fmt.Errorf("main() has no %s to render", field)
+	}
+
+	frames := make([]flameFrame, 0, 64)
+	maxDepth := 0
+
+	var layout func(n *CallNode, x, w float64, depth int)
+	layout = func(n *CallNode, x, w float64, depth int) {
+		if w*innerWidth < flameGraphMinWidth {
+			return
+		}
+
+		frames = append(frames, flameFrame{node: n, x: x, w: w, depth: depth})
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+
+		childX := x
+		for _, c := range n.Children {
+			childW := flameValue(c, field) / total
+			if childX+childW > x+w {
+				childW = x + w - childX
+			}
+			if childW <= 0 {
+				continue
+			}
+
+			layout(c, childX, childW, depth+1)
+			childX += childW
+		}
+	}
+	layout(root, 0, 1, 0)
+
+	height := flameGraphTop + (maxDepth+1)*flameGraphFrameHeight + flameGraphBottom
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<?xml version=\"1.0\" standalone=\"no\"?>\n")
+	fmt.Fprintf(&b, "<svg version=\"1.1\" width=\"%d\" height=\"%d\" onload=\"init(evt)\" viewBox=\"0 0 %d %d\" xmlns=\"http://www.w3.org/2000/svg\">\n", flameGraphWidth, height, flameGraphWidth, height)
+	fmt.Fprintf(&b, "<style type=\"text/css\">\n%s</style>\n", flameGraphStyle)
+	fmt.Fprintf(&b, "<script type=\"text/ecmascript\"><![CDATA[\n%s]]></script>\n", flameGraphScript)
+	fmt.Fprintf(&b, "<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#f8f8f8\"/>\n", flameGraphWidth, height)
+	fmt.Fprintf(&b, "<text id=\"title\" x=\"%d\" y=\"24\">%s</text>\n", flameGraphWidth/2, html.EscapeString(title))
+	fmt.Fprintf(&b, "<text id=\"unzoom\" x=\"%d\" y=\"24\" class=\"hide\">Reset Zoom</text>\n", flameGraphPadding)
+	fmt.Fprintf(&b, "<text id=\"details\" x=\"%d\" y=\"%d\"> </text>\n", flameGraphPadding, height-10)
+	fmt.Fprintf(&b, "<g id=\"frames\" data-width=\"%.0f\" data-padding=\"%d\">\n", innerWidth, flameGraphPadding)
+
+	for _, f := range frames {
+		value := flameValue(f.node, field)
+		info := fmt.Sprintf("%s (%.2f %s, %.2f%%)", f.node.Name, value/float64(divisor), unit, 100*value/total)
+		x := flameGraphPadding + f.x*innerWidth
+		w := f.w * innerWidth
+		y := height - flameGraphBottom - (f.depth+1)*flameGraphFrameHeight
+
+		fmt.Fprintf(&b, "<g class=\"frame\" data-x=\"%f\" data-w=\"%f\" data-d=\"%d\" data-n=\"%s\">", f.x, f.w, f.depth, html.EscapeString(f.node.Name))
+		fmt.Fprintf(&b, "<title>%s</title>", html.EscapeString(info))
+		fmt.Fprintf(&b, "<rect x=\"%.1f\" y=\"%d\" width=\"%.1f\" height=\"%d\" fill=\"%s\" rx=\"2\" ry=\"2\"/>", x, y, w, flameGraphFrameHeight-1, flameColor(f.node.Name))
+		fmt.Fprintf(&b, "<text x=\"%.1f\" y=\"%d\">%s</text>", x+3, y+flameGraphFrameHeight-4, html.EscapeString(flameLabel(f.node.Name, w)))
+		fmt.Fprintf(&b, "</g>\n")
+	}
+
+	fmt.Fprintf(&b, "</g>\n</svg>\n")
+
+	return b.String(), nil
+}
+
+func flameValue(n *CallNode, field string) float64 {
+	return math.Max(float64(n.Inclusive.GetFloat32Field(field)), 0)
+}
+
+func flameLabel(name string, width float64) string {
+	chars := int((width - 6) / flameGraphCharWidth)
+	if chars < 3 {
+		return ""
+	}
+
+	runes := []rune(name)
+	if len(runes) <= chars {
+		return name
+	}
+
+	return string(runes[:chars-2]) + ".."
+}
+
+func flameColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	v := h.Sum32()
+
+	r := 205 + v%50
+	g := (v >> 8) % 230
+	b := (v >> 16) % 55
+
+	return fmt.Sprintf("rgb(%d,%d,%d)", r, g, b)
+}
+
+const flameGraphStyle = `text { font-family: Verdana, sans-serif; font-size: 12px; fill: #000; }
+#title { text-anchor: middle; font-size: 17px; }
+#unzoom { cursor: pointer; }
+.frame text { font-family: monospace; pointer-events: none; }
+.frame:hover rect { stroke: #000; stroke-width: 0.5; cursor: pointer; }
+.hide { display: none; }
+.dim { opacity: 0.5; }
+`
+
+const flameGraphScript = `var fgFrames, fgDetails, fgUnzoom, fgWidth, fgPadding;
+
+function init(evt) {
+	fgFrames = document.getElementById("frames");
+	fgDetails = document.getElementById("details").firstChild;
+	fgUnzoom = document.getElementById("unzoom");
+	fgWidth = parseFloat(fgFrames.getAttribute("data-width"));
+	fgPadding = parseFloat(fgFrames.getAttribute("data-padding"));
+
+	fgFrames.addEventListener("mouseover", function(e) {
+		var g = frameOf(e.target);
+		if (g) fgDetails.nodeValue = g.getElementsByTagName("title")[0].textContent;
+	});
+	fgFrames.addEventListener("mouseout", function(e) {
+		fgDetails.nodeValue = " ";
+	});
+	fgFrames.addEventListener("click", function(e) {
+		var g = frameOf(e.target);
+		if (g) zoom(g);
+	});
+	fgUnzoom.addEventListener("click", function(e) {
+		zoom(null);
+	});
+}
+
+function frameOf(el) {
+	while (el && el !== fgFrames) {
+		if (el.getAttribute && /(^| )frame( |$)/.test(el.getAttribute("class"))) return el;
+		el = el.parentNode;
+	}
+	return null;
+}
+
+function attr(g, name) {
+	return parseFloat(g.getAttribute(name));
+}
+
+function place(g, x, w) {
+	var rect = g.getElementsByTagName("rect")[0];
+	var text = g.getElementsByTagName("text")[0];
+	var name = g.getAttribute("data-n");
+	var px = fgPadding + x * fgWidth, pw = w * fgWidth;
+	var chars = Math.floor((pw - 6) / 7);
+
+	rect.setAttribute("x", px.toFixed(1));
+	rect.setAttribute("width", pw.toFixed(1));
+	text.setAttribute("x", (px + 3).toFixed(1));
+	if (chars < 3) {
+		text.textContent = "";
+	} else if (name.length <= chars) {
+		text.textContent = name;
+	} else {
+		text.textContent = name.substring(0, chars - 2) + "..";
+	}
+}
+
+function zoom(target) {
+	var x0 = 0, w0 = 1, d0 = 0, eps = 0.000001;
+	if (target) {
+		x0 = attr(target, "data-x");
+		w0 = attr(target, "data-w");
+		d0 = attr(target, "data-d");
+	}
+
+	fgUnzoom.setAttribute("class", target ? "" : "hide");
+
+	var all = fgFrames.getElementsByTagName("g");
+	for (var i = 0; i < all.length; i++) {
+		var g = all[i];
+		var x = attr(g, "data-x"), w = attr(g, "data-w"), d = attr(g, "data-d");
+		g.setAttribute("class", "frame");
+
+		if (d < d0) {
+			if (x <= x0 + eps && x + w >= x0 + w0 - eps) {
+				g.setAttribute("class", "frame dim");
+				place(g, 0, 1);
+			} else {
+				g.setAttribute("class", "frame hide");
+			}
+		} else if (x >= x0 - eps && x + w <= x0 + w0 + eps) {
+			place(g, (x - x0) / w0, w / w0);
+		} else {
+			g.setAttribute("class", "frame hide");
+		}
+	}
+}
+`
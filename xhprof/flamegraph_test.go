@@ -0,0 +1,56 @@
+package xhprof
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateFlameGraph(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 1000,
+				Count:    1,
+				CpuTime:  400,
+			},
+			"main()==>foo": &PairCall{
+				WallTime: 500,
+				Count:    2,
+				CpuTime:  200,
+			},
+			"foo==>bar<baz>": &PairCall{
+				WallTime: 200,
+				Count:    10,
+				CpuTime:  100,
+			},
+		},
+	}
+
+	svg, err := GenerateFlameGraph(m, "WallTime", "Flame Graph", "ms", 1000, 0)
+	require.Nil(t, err)
+
+	assert.True(t, strings.HasPrefix(svg, "<?xml"))
+	assert.Equal(t, 3, strings.Count(svg, "<g class=\"frame\""))
+	assert.Contains(t, svg, "<title>main() (1.00 ms, 100.00%)</title>")
+	assert.Contains(t, svg, "<title>foo (0.50 ms, 50.00%)</title>")
+	assert.Contains(t, svg, "data-n=\"bar&lt;baz&gt;\"")
+	assert.Contains(t, svg, "data-x=\"0.000000\" data-w=\"0.200000\" data-d=\"2\"")
+
+	_, err = GenerateFlameGraph(m, "Memory", "Flame Graph", "KB", 1024, 0)
+	assert.NotNil(t, err)
+}
+
+func TestFlameLabel(t *testing.T) {
+	assert.Equal(t, "", flameLabel("foo", 20))
+	assert.Equal(t, "foo", flameLabel("foo", 41))
+	assert.Equal(t, "Bar::..", flameLabel("Bar::baz()", 55))
+
+	// Multi-byte characters are never split.
+	assert.Equal(t, "Größe", flameLabel("Größe", 41))
+	assert.Equal(t, "Grö..", flameLabel("Größenberechnung", 41))
+	assert.True(t, utf8.ValidString(flameLabel("Ärger\\Überprüfung::öffnen", 76)))
+}
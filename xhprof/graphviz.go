@@ -30,9 +30,13 @@ func GenerateDotScript(m *PairCallMap, threshold float32, function string, criti
 
 	if function != "" {
 		relatedFuncs := getRelatedFuncs(m, function)
-		for name, _ := range callMap {
+		if len(relatedFuncs) == 0 {
+			return "", fmt.Errorf("Call map has no function %s", function)
+		}
+
+		for name := range callMap {
 			if _, ok := relatedFuncs[name]; !ok {
-				delete(relatedFuncs, name)
+				delete(callMap, name)
 			}
 		}
 	}
@@ -102,16 +106,16 @@ func GenerateDotScript(m *PairCallMap, threshold float32, function string, criti
 				label = fmt.Sprintf(
 					", label=\"%s\\nInc: %.3f ms - %.3f ms = %.3f ms\\nExcl: %.3f ms - %.3f ms = %.3f ms\\nCalls: %d - %d = %d\"",
 					name,
-					leftC.WallTime/1000, 0, c.WallTime/1000,
-					leftC.ExclusiveWallTime/1000, 0, c.ExclusiveWallTime/1000,
+					leftC.WallTime/1000, 0.0, c.WallTime/1000,
+					leftC.ExclusiveWallTime/1000, 0.0, c.ExclusiveWallTime/1000,
 					leftC.Count, 0, c.Count,
 				)
 			} else {
 				label = fmt.Sprintf(
 					", label=\"%s\\nInc: %.3f ms - %.3f ms = %.3f ms\\nExcl: %.3f ms - %.3f ms = %.3f ms\\nCalls: %d - %d = %d\"",
 					name,
-					0, rightC.WallTime/1000, c.WallTime/1000,
-					0, rightC.ExclusiveWallTime/1000, c.ExclusiveWallTime/1000,
+					0.0, rightC.WallTime/1000, c.WallTime/1000,
+					0.0, rightC.ExclusiveWallTime/1000, c.ExclusiveWallTime/1000,
 					0, rightC.Count, c.Count,
 				)
 			}
@@ -136,6 +140,12 @@ func GenerateDotScript(m *PairCallMap, threshold float32, function string, criti
 			continue
 		}
 
+		// Calls of a group to itself only correct its inclusive costs, see
+		// Group.
+		if isGroupCorrection(parent, child, c) && left == nil {
+			continue
+		}
+
 		label := "1 call"
 		if c.Count != 1 {
 			label = fmt.Sprintf("%d calls", c.Count)
@@ -169,12 +179,16 @@ func GenerateDotScript(m *PairCallMap, threshold float32, function string, criti
 	return result, nil
 }
 
-func GenerateDiffDotScript(m1, m2 *PairCallMap, threshold float32) (string, error) {
+// GenerateDiffDotScript generates the graph of the difference of m2 to m1,
+// restricted to the functions directly related to function if it is not
+// empty, and with the path of the largest changes highlighted if criticalPath
+// is set.
+func GenerateDiffDotScript(m1, m2 *PairCallMap, threshold float32, function string, criticalPath bool) (string, error) {
 	right := m1.GetCallMap()
 	left := m2.GetCallMap()
 	diff := m2.Subtract(m1)
 
-	return GenerateDotScript(diff, threshold, "", true, right, left)
+	return GenerateDotScript(diff, threshold, function, criticalPath, right, left)
 }
 
 func getCriticalPath(m *PairCallMap) (map[string]bool, map[string]bool) {
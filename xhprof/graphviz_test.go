@@ -0,0 +1,67 @@
+package xhprof
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newGraphvizTestMaps() (*PairCallMap, *PairCallMap) {
+	m1 := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":       &PairCall{Count: 1, WallTime: 1000},
+			"main()==>foo": &PairCall{Count: 1, WallTime: 600},
+			"main()==>baz": &PairCall{Count: 1, WallTime: 300},
+			"foo==>bar":    &PairCall{Count: 2, WallTime: 400},
+		},
+	}
+	m2 := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":       &PairCall{Count: 1, WallTime: 1500},
+			"main()==>foo": &PairCall{Count: 1, WallTime: 1100},
+			"main()==>baz": &PairCall{Count: 1, WallTime: 300},
+			"foo==>bar":    &PairCall{Count: 4, WallTime: 800},
+		},
+	}
+
+	return m1, m2
+}
+
+func TestGenerateDotScriptFunction(t *testing.T) {
+	m, _ := newGraphvizTestMaps()
+
+	dot, err := GenerateDotScript(m, 0.01, "foo", false, nil, nil)
+	require.Nil(t, err)
+	assert.Contains(t, dot, "main()")
+	assert.Contains(t, dot, "foo\\nInc")
+	assert.Contains(t, dot, "bar\\nInc")
+	assert.NotContains(t, dot, "baz")
+	assert.Equal(t, 2, strings.Count(dot, "->"))
+
+	_, err = GenerateDotScript(m, 0.01, "unknown", false, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestGenerateDiffDotScript(t *testing.T) {
+	m1, m2 := newGraphvizTestMaps()
+
+	dot, err := GenerateDiffDotScript(m1, m2, 0.01, "", true)
+	require.Nil(t, err)
+	assert.Contains(t, dot, "Inc: 1.100 ms - 0.600 ms = 0.500 ms")
+	assert.Contains(t, dot, "fillcolor=yellow")
+	assert.Contains(t, dot, "setlinewidth(10)")
+
+	dot, err = GenerateDiffDotScript(m1, m2, 0.01, "", false)
+	require.Nil(t, err)
+	assert.NotContains(t, dot, "fillcolor=yellow")
+	assert.NotContains(t, dot, "setlinewidth(10)")
+
+	dot, err = GenerateDiffDotScript(m1, m2, 0.01, "bar", false)
+	require.Nil(t, err)
+	assert.Contains(t, dot, "Calls: 4 - 2 = 2")
+	assert.NotContains(t, dot, "main()")
+	assert.NotContains(t, dot, "baz")
+	assert.Equal(t, 1, strings.Count(dot, "->"))
+}
@@ -0,0 +1,308 @@
+package xhprof
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GroupFunc returns the group of a function, or the function itself if it
+// belongs to no group.
+type GroupFunc func(fn string) string
+
+// Group rolls the functions of the map up into the groups returned by group,
+// e.g. their classes, so that the exclusive costs of a group are the sum of
+// the exclusive costs of its functions, and its inclusive costs are those of
+// the calls into it that are not nested within the same group. main() always
+// remains a group of its own.
+//
+// The calls between groups are the sums of the calls between their
+// functions. A call into a group that is already on the stack through other
+// groups, like a callback of the application called from a library, is kept
+// as a call from the group that makes it, together with a call of the group
+// to itself with negative costs, which takes it out of the inclusive costs of
+// the group again when the map is flattened. The share of such calls that is
+// nested within the group is estimated from the calls into their callers,
+// like for Ignore.
+func (m *PairCallMap) Group(group GroupFunc) *PairCallMap {
+	groupOf := func(fn string) string {
+		if fn == "" || fn == "main()" {
+			return fn
+		}
+
+		return group(fn)
+	}
+
+	r := NewPairCallMap()
+	r.Meta = m.Meta
+	r.Sources = m.groupSources(group)
+
+	g := newCallGraph(m)
+	members := make(map[string][]string)
+	for _, fn := range g.functions() {
+		members[groupOf(fn)] = append(members[groupOf(fn)], fn)
+	}
+
+	for parent, children := range g.children {
+		pg := groupOf(parent)
+		for child, pc := range children {
+			// Recursive calls of a function are kept like in the map,
+			// other calls within a group are nested within it.
+			cg := groupOf(child)
+			if pg == cg && (parent != child || cg != child) {
+				continue
+			}
+
+			r.NewPairCall(pairName(pg, cg)).Add(pc)
+		}
+	}
+
+	shares := g.callerShares()
+	for cg, fns := range members {
+		nested := g.nestedFractions(fns, shares)
+		if nested == nil {
+			continue
+		}
+
+		correction, incoming, outgoing, lower := new(PairCall), new(PairCall), new(PairCall), new(PairCall)
+		var count float64
+		for _, fn := range fns {
+			inclusive := new(PairCall)
+			for parent, pc := range g.parents[fn] {
+				if parent != fn {
+					inclusive.Add(pc)
+				}
+
+				if groupOf(parent) == cg {
+					continue
+				}
+
+				incoming.Add(pc)
+				if f := nested[parent]; f > 0 {
+					correction.Add(scalePairCall(pc, f))
+					count += float64(pc.Count) * f
+				}
+			}
+			lower = combinePairCalls(lower, inclusive, math.Max)
+
+			for child, pc := range g.children[fn] {
+				if groupOf(child) != cg {
+					outgoing.Add(pc)
+				}
+			}
+		}
+
+		// The estimate is limited so that the inclusive costs of the group
+		// are at least its exclusive costs and the inclusive costs of each
+		// of its functions, and that it is still called at least once.
+		lower = combinePairCalls(lower, incoming.Copy().Subtract(outgoing), math.Max)
+		limit := combinePairCalls(incoming.Copy().Subtract(lower), new(PairCall), math.Max)
+		correction = combinePairCalls(correction, limit, math.Min)
+		correction.Count = int(math.Max(0, math.Min(math.Round(count), float64(incoming.Count-1))))
+
+		if !isZeroPairCall(correction) {
+			r.NewPairCall(pairName(cg, cg)).Subtract(correction)
+		}
+	}
+
+	return r
+}
+
+// nestedFractions returns the fraction of the costs of each function that is
+// spent within calls of the functions fns of a group, or nil if none of the
+// functions called within them calls back into the group. A group of a single
+// function keeps its costs as they are in the map, which are those of the
+// function.
+func (g *callGraph) nestedFractions(fns []string, shares map[string]map[string]float64) map[string]float64 {
+	if len(fns) < 2 {
+		return nil
+	}
+
+	fixed := map[string]float64{"": 0}
+	for _, fn := range fns {
+		fixed[fn] = 1
+	}
+
+	reentered := false
+	below := make(map[string]bool)
+	queue := append([]string(nil), fns...)
+	for len(queue) > 0 {
+		fn := queue[0]
+		queue = queue[1:]
+
+		for child := range g.children[fn] {
+			switch _, ok := fixed[child]; {
+			case ok:
+				reentered = reentered || below[fn]
+			case !below[child]:
+				below[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	if !reentered {
+		return nil
+	}
+
+	// Only the functions called within the group can be nested within it.
+	nested := make(map[string]map[string]float64, len(below))
+	for fn := range below {
+		nested[fn] = shares[fn]
+	}
+
+	return g.keptFractions(nested, fixed)
+}
+
+// combinePairCalls returns a pair call with f applied to each of the costs of
+// a and b, like math.Max.
+func combinePairCalls(a, b *PairCall, f func(x, y float64) float64) *PairCall {
+	r := new(PairCall)
+	aggregateFields(r, []interface{}{a, b}, func(values []float64) float64 {
+		return f(values[0], values[1])
+	})
+
+	return r
+}
+
+// isZeroPairCall returns whether all costs of pc are 0.
+func isZeroPairCall(pc *PairCall) bool {
+	zero := true
+	aggregateFields(new(PairCall), []interface{}{pc}, func(values []float64) float64 {
+		zero = zero && values[0] == 0
+		return 0
+	})
+
+	return zero
+}
+
+// isGroupCorrection returns whether the call from parent to child only
+// corrects the inclusive costs of a group, see Group.
+func isGroupCorrection(parent, child string, pc *PairCall) bool {
+	return parent == child && pc.Count <= 0
+}
+
+// groupSources returns the sources of the groups, which combine the lines and
+// call sites of their functions if all of them are defined in the same file,
+// like for groups by file. The sources of functions that remain on their own
+// are kept as they are, except that the functions they call are replaced by
+// their groups.
+func (m *PairCallMap) groupSources(group GroupFunc) map[string]*Source {
+	if m.Sources == nil {
+		return nil
+	}
+
+	members := make(map[string][]string)
+	for fn := range m.Sources {
+		g := fn
+		if fn != "main()" {
+			g = group(fn)
+		}
+
+		members[g] = append(members[g], fn)
+	}
+
+	sources := make(map[string]*Source, len(members))
+	for g, fns := range members {
+		sort.Strings(fns)
+
+		file := m.Sources[fns[0]].File
+		line := 0
+		for _, fn := range fns {
+			s := m.Sources[fn]
+			if s.File != file {
+				file = ""
+				break
+			}
+
+			if s.Line > 0 && (line == 0 || s.Line < line) {
+				line = s.Line
+			}
+		}
+
+		if file == "" && len(fns) > 1 {
+			continue
+		}
+
+		src := newSource()
+		src.File, src.Line = file, line
+		for _, fn := range fns {
+			for l, costs := range m.Sources[fn].Lines {
+				src.AddLineCosts(l, costs)
+			}
+
+			for _, c := range m.Sources[fn].CallSites {
+				src.AddCallSite(group(c.Function), c.File, c.Line, c.Count)
+			}
+		}
+
+		sources[g] = src
+	}
+
+	return sources
+}
+
+// GroupByClass groups methods by their class, like "WP_Hook" for
+// "WP_Hook::apply_filters".
+func GroupByClass(fn string) string {
+	if i := strings.Index(fn, "::"); i > 0 {
+		return fn[:i]
+	}
+
+	return fn
+}
+
+// GroupByNamespace returns a GroupFunc that groups functions and methods by
+// the first depth parts of their namespace, or by their whole namespace if
+// depth is 0, like "Doctrine" or "Doctrine\ORM" for
+// "Doctrine\ORM\EntityManager::find". Classes and functions without namespace
+// are grouped by class.
+func GroupByNamespace(depth int) GroupFunc {
+	return func(fn string) string {
+		class := GroupByClass(fn)
+
+		i := strings.LastIndex(class, "\\")
+		if i <= 0 {
+			return class
+		}
+
+		namespace := class[:i]
+		if depth > 0 {
+			if parts := strings.SplitN(namespace, "\\", depth+1); len(parts) > depth {
+				namespace = strings.Join(parts[:depth], "\\")
+			}
+		}
+
+		return namespace
+	}
+}
+
+// GroupByRegexp returns a GroupFunc that groups functions by the first
+// submatch of re, or by the whole match if re has no groups. Functions that
+// don't match remain on their own.
+func GroupByRegexp(re *regexp.Regexp) GroupFunc {
+	return func(fn string) string {
+		match := re.FindStringSubmatch(fn)
+		switch {
+		case match == nil:
+			return fn
+		case len(match) > 1 && match[1] != "":
+			return match[1]
+		}
+
+		return match[0]
+	}
+}
+
+// GroupByFile returns a GroupFunc that groups functions by the file they are
+// defined in, as far as the profile format of m provides it.
+func (m *PairCallMap) GroupByFile() GroupFunc {
+	return func(fn string) string {
+		if s, ok := m.Sources[fn]; ok && s.File != "" {
+			return s.File
+		}
+
+		return fn
+	}
+}
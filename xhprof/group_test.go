@@ -0,0 +1,210 @@
+package xhprof
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPairCallMapGroup(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":                       &PairCall{Count: 1, WallTime: 1000},
+			"main()==>App\\Kernel::handle": &PairCall{Count: 1, WallTime: 900},
+			"App\\Kernel::handle==>App\\Controller::index":                           &PairCall{Count: 1, WallTime: 800},
+			"App\\Controller::index==>Doctrine\\ORM\\EntityManager::find":            &PairCall{Count: 3, WallTime: 300},
+			"Doctrine\\ORM\\EntityManager::find==>Doctrine\\DBAL\\Connection::query": &PairCall{Count: 3, WallTime: 200},
+			"Doctrine\\DBAL\\Connection::query==>mysqli_query":                       &PairCall{Count: 3, WallTime: 150},
+			"App\\Controller::index==>Twig\\Environment::render":                     &PairCall{Count: 1, WallTime: 400},
+			"Twig\\Environment::render==>App\\Twig\\Extension::url":                  &PairCall{Count: 5, WallTime: 100},
+		},
+		Meta: map[string]string{"url": "/"},
+	}
+
+	grouped := m.Group(GroupByNamespace(1))
+	assert.Equal(t, m.Meta, grouped.Meta)
+
+	profile := grouped.Flatten()
+	require.NotNil(t, profile.Main)
+	assert.Equal(t, float32(1000), profile.Main.WallTime)
+
+	// App\Twig\Extension::url runs within App\Kernel::handle, so it is not
+	// counted twice in the inclusive costs of App.
+	app := profile.GetCall("App")
+	require.NotNil(t, app)
+	assert.Equal(t, 1, app.Count)
+	assert.Equal(t, float32(900), app.WallTime)
+	assert.Equal(t, float32(300), app.ExclusiveWallTime)
+
+	doctrine := profile.GetCall("Doctrine")
+	require.NotNil(t, doctrine)
+	assert.Equal(t, 3, doctrine.Count)
+	assert.Equal(t, float32(300), doctrine.WallTime)
+	assert.Equal(t, float32(150), doctrine.ExclusiveWallTime)
+
+	twig := profile.GetCall("Twig")
+	require.NotNil(t, twig)
+	assert.Equal(t, float32(400), twig.WallTime)
+	assert.Equal(t, float32(300), twig.ExclusiveWallTime)
+
+	var exclusive float32
+	for _, c := range profile.Calls {
+		exclusive += c.ExclusiveWallTime
+	}
+	assert.Equal(t, float32(1000), exclusive)
+}
+
+func TestPairCallMapGroupReentry(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":            &PairCall{Count: 1, WallTime: 100},
+			"main()==>A\\X::f":  &PairCall{Count: 1, WallTime: 100},
+			"A\\X::f==>B\\Y::g": &PairCall{Count: 1, WallTime: 80},
+			"B\\Y::g==>A\\X::h": &PairCall{Count: 1, WallTime: 50},
+			"A\\X::h==>A\\X::i": &PairCall{Count: 2, WallTime: 20},
+		},
+	}
+
+	grouped := m.Group(GroupByNamespace(0))
+
+	profile := grouped.Flatten()
+	assert.Equal(t, float32(100), profile.Main.WallTime)
+	assert.Equal(t, float32(0), profile.Main.ExclusiveWallTime)
+
+	a := profile.GetCall("A")
+	require.NotNil(t, a)
+	assert.Equal(t, 1, a.Count)
+	assert.Equal(t, float32(100), a.WallTime)
+	assert.Equal(t, float32(20+50), a.ExclusiveWallTime)
+
+	b := profile.GetCall("B")
+	require.NotNil(t, b)
+	assert.Equal(t, 1, b.Count)
+	assert.Equal(t, float32(80), b.WallTime)
+	assert.Equal(t, float32(30), b.ExclusiveWallTime)
+}
+
+func TestPairCallMapGroupIdentity(t *testing.T) {
+	m, err := NewFile("../tests/data/wp-index.xhprof", "xhprof").GetPairCallMap()
+	require.Nil(t, err)
+
+	// Grouping every function by itself gives back the same profile.
+	expected := m.Flatten().Calls
+	actual := m.Group(GroupByRegexp(regexp.MustCompile(`^NOMATCH$`))).Flatten().Calls
+	assert.Len(t, actual, len(expected))
+	assert.ElementsMatch(t, expected, actual)
+}
+
+func TestPairCallMapGroupProfiles(t *testing.T) {
+	for _, path := range []string{"../tests/data/cachet.xhprof", "../tests/data/oxid.xhprof"} {
+		m, err := NewFile(path, "xhprof").GetPairCallMap()
+		require.Nil(t, err)
+
+		// The estimated nesting never takes a group below its exclusive
+		// costs, which add up to the total.
+		profile := m.Group(GroupByClass).Flatten()
+		var exclusive float32
+		for _, c := range profile.Calls {
+			exclusive += c.ExclusiveWallTime
+			assert.True(t, c.Count >= 1, c.Name)
+			assert.True(t, c.WallTime+0.01 >= c.ExclusiveWallTime, c.Name)
+		}
+		assert.InDelta(t, profile.Main.WallTime, exclusive, 0.5, path)
+	}
+}
+
+func TestPairCallMapGroupSources(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()":       &PairCall{Count: 1, WallTime: 100},
+			"main()==>foo": &PairCall{Count: 1, WallTime: 90},
+			"foo==>bar":    &PairCall{Count: 2, WallTime: 50},
+			"bar==>baz":    &PairCall{Count: 2, WallTime: 20},
+			"baz==>strlen": &PairCall{Count: 2, WallTime: 5},
+		},
+	}
+	m.NewSource("main()").File = "index.php"
+	m.Sources["main()"].AddCallSite("foo", "index.php", 3, 1)
+	for fn, line := range map[string]int{"foo": 10, "bar": 4} {
+		m.NewSource(fn).File = "a.php"
+		m.Sources[fn].Line = line
+		m.Sources[fn].AddLineCosts(line+1, &PairCall{WallTime: 20})
+	}
+	m.Sources["foo"].AddCallSite("bar", "a.php", 11, 2)
+	m.Sources["bar"].AddCallSite("baz", "a.php", 5, 2)
+	m.NewSource("baz").File = "b.php"
+	m.Sources["baz"].Line = 7
+
+	grouped := m.Group(m.GroupByFile())
+
+	assert.Equal(t, &Source{
+		File:      "index.php",
+		Lines:     map[int]*PairCall{},
+		CallSites: []*CallSite{&CallSite{Function: "a.php", File: "index.php", Line: 3, Count: 1}},
+	}, grouped.Sources["main()"])
+
+	a := grouped.Sources["a.php"]
+	require.NotNil(t, a)
+	assert.Equal(t, "a.php", a.File)
+	assert.Equal(t, 4, a.Line)
+	assert.Equal(t, []int{5, 11}, a.SortedLines())
+	assert.Equal(t, []*CallSite{
+		&CallSite{Function: "b.php", File: "a.php", Line: 5, Count: 2},
+		&CallSite{Function: "a.php", File: "a.php", Line: 11, Count: 2},
+	}, a.CallSites)
+
+	profile := grouped.Flatten()
+	b := profile.GetCall("b.php")
+	require.NotNil(t, b)
+	assert.Equal(t, "b.php", b.File)
+	assert.Equal(t, 7, b.Line)
+
+	// Groups of functions defined in different files have no source.
+	grouped = m.Group(GroupByRegexp(regexp.MustCompile(`^ba`)))
+	assert.NotContains(t, grouped.Sources, "ba")
+	assert.Equal(t, 10, grouped.Sources["foo"].Line)
+	assert.Equal(t, "ba", grouped.Sources["foo"].CallSites[0].Function)
+}
+
+func TestGroupByClass(t *testing.T) {
+	assert.Equal(t, "WP_Hook", GroupByClass("WP_Hook::apply_filters"))
+	assert.Equal(t, "Foo\\Bar", GroupByClass("Foo\\Bar::baz@1"))
+	assert.Equal(t, "strlen", GroupByClass("strlen"))
+	assert.Equal(t, "{closure}", GroupByClass("{closure}"))
+}
+
+func TestGroupByNamespace(t *testing.T) {
+	fn := "Doctrine\\ORM\\EntityManager::find"
+
+	assert.Equal(t, "Doctrine\\ORM", GroupByNamespace(0)(fn))
+	assert.Equal(t, "Doctrine", GroupByNamespace(1)(fn))
+	assert.Equal(t, "Doctrine\\ORM", GroupByNamespace(2)(fn))
+	assert.Equal(t, "Doctrine\\ORM", GroupByNamespace(5)(fn))
+	assert.Equal(t, "Foo", GroupByNamespace(0)("Foo\\bar"))
+	assert.Equal(t, "WP_Hook", GroupByNamespace(1)("WP_Hook::apply_filters"))
+	assert.Equal(t, "strlen", GroupByNamespace(1)("strlen"))
+}
+
+func TestGroupByRegexp(t *testing.T) {
+	group := GroupByRegexp(regexp.MustCompile(`^(Doctrine|Twig)\\`))
+	assert.Equal(t, "Doctrine", group("Doctrine\\ORM\\EntityManager::find"))
+	assert.Equal(t, "Twig", group("Twig\\Environment::render"))
+	assert.Equal(t, "App\\Kernel::handle", group("App\\Kernel::handle"))
+
+	group = GroupByRegexp(regexp.MustCompile(`^mysqli?_`))
+	assert.Equal(t, "mysqli_", group("mysqli_query"))
+	assert.Equal(t, "strlen", group("strlen"))
+}
+
+func TestPairCallMapGroupByFile(t *testing.T) {
+	m := NewPairCallMap()
+	m.NewSource("foo").File = "src/foo.php"
+	m.NewSource("bar")
+
+	group := m.GroupByFile()
+	assert.Equal(t, "src/foo.php", group("foo"))
+	assert.Equal(t, "bar", group("bar"))
+	assert.Equal(t, "baz", group("baz"))
+}
@@ -1,9 +1,14 @@
 package xhprof
 
 import (
+	"reflect"
 	"strings"
 )
 
+// costsPrefix selects a value of the Costs map in GetFloat32Field, e.g.
+// "Costs.Ir" for the Ir event of a valgrind profile.
+const costsPrefix = "Costs."
+
 type PairCall struct {
 	Count       int     `json:"ct"`
 	WallTime    float32 `json:"wt"`
@@ -13,6 +18,23 @@ type PairCall struct {
 	NumAlloc    float32 `json:"mem.na"`
 	NumFree     float32 `json:"mem.nf"`
 	AllocAmount float32 `json:"mem.aa"`
+
+	// Costs holds events of other profilers that have no XHProf equivalent,
+	// like the instruction counts of valgrind.
+	Costs map[string]float32 `json:"costs,omitempty"`
+}
+
+func (p *PairCall) GetFloat32Field(field string) float32 {
+	if strings.HasPrefix(field, costsPrefix) {
+		return p.Costs[strings.TrimPrefix(field, costsPrefix)]
+	}
+
+	pVal := reflect.Indirect(reflect.ValueOf(p)).FieldByName(field)
+	if pVal.Kind() == reflect.Int {
+		return float32(pVal.Int())
+	}
+
+	return float32(pVal.Float())
 }
 
 func (p *PairCall) Add(o *PairCall) *PairCall {
@@ -24,6 +46,7 @@ func (p *PairCall) Add(o *PairCall) *PairCall {
 	p.NumAlloc += o.NumAlloc
 	p.NumFree += o.NumFree
 	p.AllocAmount += o.AllocAmount
+	p.Costs = addCosts(p.Costs, o.Costs, 1)
 
 	return p
 }
@@ -37,6 +60,9 @@ func (p *PairCall) Divide(d float32) *PairCall {
 	p.NumAlloc /= d
 	p.NumFree /= d
 	p.AllocAmount /= d
+	for k, v := range p.Costs {
+		p.Costs[k] = v / d
+	}
 
 	return p
 }
@@ -50,10 +76,37 @@ func (p *PairCall) Subtract(o *PairCall) *PairCall {
 	p.NumAlloc -= o.NumAlloc
 	p.NumFree -= o.NumFree
 	p.AllocAmount -= o.AllocAmount
+	p.Costs = addCosts(p.Costs, o.Costs, -1)
 
 	return p
 }
 
+// Copy returns a deep copy of the pair call.
+func (p *PairCall) Copy() *PairCall {
+	c := new(PairCall)
+	*c = *p
+	c.Costs = addCosts(nil, p.Costs, 1)
+
+	return c
+}
+
+// addCosts adds the costs of o multiplied by f to m, creating m if needed.
+func addCosts(m, o map[string]float32, f float32) map[string]float32 {
+	if len(o) == 0 {
+		return m
+	}
+
+	if m == nil {
+		m = make(map[string]float32, len(o))
+	}
+
+	for k, v := range o {
+		m[k] += f * v
+	}
+
+	return m
+}
+
 type NearestFamily struct {
 	Children      *PairCallMap
 	Parents       *PairCallMap
@@ -71,6 +124,14 @@ func NewNearestFamily() *NearestFamily {
 
 type PairCallMap struct {
 	M map[string]*PairCall
+
+	// Sources holds the source location of functions, if the profile format
+	// provides it (e.g. callgrind), and is nil otherwise.
+	Sources map[string]*Source
+
+	// Meta holds data about the profiled request, like the URL of XHGui
+	// runs, if the profile format provides it, and is nil otherwise.
+	Meta map[string]string
 }
 
 func NewPairCallMap() *PairCallMap {
@@ -92,6 +153,24 @@ func (m *PairCallMap) NewPairCall(name string) *PairCall {
 	return pc
 }
 
+// NewSource returns the source location of function fn, creating it if it
+// does not exist yet.
+func (m *PairCallMap) NewSource(fn string) *Source {
+	if m.Sources == nil {
+		m.Sources = make(map[string]*Source)
+	}
+
+	s, ok := m.Sources[fn]
+	if ok {
+		return s
+	}
+
+	s = newSource()
+	m.Sources[fn] = s
+
+	return s
+}
+
 func (m *PairCallMap) GetCallMap() map[string]*Call {
 	symbols := make(map[string]*Call)
 	for name, info := range m.M {
@@ -126,6 +205,11 @@ func (m *PairCallMap) Flatten() *Profile {
 	profile := new(Profile)
 	calls := make([]*Call, 0, len(symbols))
 	for _, call := range symbols {
+		if s, ok := m.Sources[call.Name]; ok {
+			call.File = s.File
+			call.Line = s.Line
+		}
+
 		calls = append(calls, call)
 	}
 	profile.Calls = calls
@@ -143,34 +227,77 @@ func (m *PairCallMap) ComputeNearestFamily(f string) *NearestFamily {
 
 	for name, info := range m.M {
 		parent, child := parsePairName(name)
-		if parent == f {
-			c, ok := family.Children.M[child]
-			if !ok {
-				c = new(PairCall)
-				family.Children.M[child] = c
-			}
 
-			c.WallTime += info.WallTime
-			c.Count += info.Count
-			family.ChildrenCount += info.Count
+		// Calls of a group to itself only correct its inclusive costs, see
+		// Group.
+		if isGroupCorrection(parent, child, info) {
+			continue
 		}
 
-		if child == f && parent != "" {
-			p, ok := family.Parents.M[parent]
-			if !ok {
-				p = new(PairCall)
-				family.Parents.M[parent] = p
-			}
+		if parent == f {
+			family.addChild(child, info)
+		}
 
-			p.WallTime += info.WallTime
-			p.Count += info.Count
-			family.ParentsCount += info.Count
+		if child == f && parent != "" {
+			family.addParent(parent, info)
 		}
 	}
 
 	return family
 }
 
+// ComputeNearestFamilies returns the nearest family of every function of the
+// map, in one pass over its calls.
+func (m *PairCallMap) ComputeNearestFamilies() map[string]*NearestFamily {
+	families := make(map[string]*NearestFamily)
+	family := func(f string) *NearestFamily {
+		if _, ok := families[f]; !ok {
+			families[f] = NewNearestFamily()
+		}
+
+		return families[f]
+	}
+
+	for name, info := range m.M {
+		parent, child := parsePairName(name)
+		if isGroupCorrection(parent, child, info) {
+			continue
+		}
+
+		family(child)
+		if parent != "" {
+			family(parent).addChild(child, info)
+			family(child).addParent(parent, info)
+		}
+	}
+
+	return families
+}
+
+func (f *NearestFamily) addChild(child string, info *PairCall) {
+	c, ok := f.Children.M[child]
+	if !ok {
+		c = new(PairCall)
+		f.Children.M[child] = c
+	}
+
+	c.WallTime += info.WallTime
+	c.Count += info.Count
+	f.ChildrenCount += info.Count
+}
+
+func (f *NearestFamily) addParent(parent string, info *PairCall) {
+	p, ok := f.Parents.M[parent]
+	if !ok {
+		p = new(PairCall)
+		f.Parents.M[parent] = p
+	}
+
+	p.WallTime += info.WallTime
+	p.Count += info.Count
+	f.ParentsCount += info.Count
+}
+
 func (m *PairCallMap) GetChildrenMap() map[string][]string {
 	r := make(map[string][]string)
 
@@ -186,13 +313,23 @@ func (m *PairCallMap) GetChildrenMap() map[string][]string {
 	return r
 }
 
+// Copy returns a deep copy of the map, with its sources and meta data.
 func (m *PairCallMap) Copy() *PairCallMap {
 	r := NewPairCallMap()
 
 	for name, info := range m.M {
-		c := new(PairCall)
-		*c = *info
-		r.M[name] = c
+		r.M[name] = info.Copy()
+	}
+
+	for fn, s := range m.Sources {
+		r.NewSource(fn).Add(s)
+	}
+
+	if m.Meta != nil {
+		r.Meta = make(map[string]string, len(m.Meta))
+		for k, v := range m.Meta {
+			r.Meta[k] = v
+		}
 	}
 
 	return r
@@ -219,25 +356,42 @@ func AvgPairCallMaps(maps []*PairCallMap) *PairCallMap {
 		return maps[0]
 	}
 
+	res := SumPairCallMaps(maps)
+
+	num := float32(len(maps))
+	for _, v := range res.M {
+		v.Divide(num)
+	}
+	for _, src := range res.Sources {
+		src.Divide(num)
+	}
+
+	return res
+}
+
+// SumPairCallMaps adds up the costs of all maps, e.g. of the parts of a
+// callgrind file that belong to the same request.
+func SumPairCallMaps(maps []*PairCallMap) *PairCallMap {
+	if len(maps) == 1 {
+		return maps[0]
+	}
+
 	res := NewPairCallMap()
 
 	for _, m := range maps {
 		for k, v := range m.M {
 			pairCall, ok := res.M[k]
 			if !ok {
-				pairCall = new(PairCall)
-				*pairCall = *v
-				res.M[k] = pairCall
+				res.M[k] = v.Copy()
 				continue
 			}
 
 			pairCall.Add(v)
 		}
-	}
 
-	num := float32(len(maps))
-	for _, v := range res.M {
-		v.Divide(num)
+		for fn, src := range m.Sources {
+			res.NewSource(fn).Add(src)
+		}
 	}
 
 	return res
@@ -182,6 +182,50 @@ func TestAvgPairCallMaps(t *testing.T) {
 	assert.EqualValues(t, expected, res)
 }
 
+func TestSumPairCallMaps(t *testing.T) {
+	expected := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 1100,
+				Count:    2,
+				CpuTime:  500,
+			},
+			"main()==>foo": &PairCall{
+				WallTime: 600,
+				Count:    3,
+				CpuTime:  300,
+			},
+		},
+	}
+	m1 := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 800,
+				Count:    1,
+				CpuTime:  400,
+			},
+			"main()==>foo": &PairCall{
+				WallTime: 600,
+				Count:    3,
+				CpuTime:  300,
+			},
+		},
+	}
+	m2 := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 300,
+				Count:    1,
+				CpuTime:  100,
+			},
+		},
+	}
+
+	res := SumPairCallMaps([]*PairCallMap{m1, m2})
+	assert.EqualValues(t, expected, res)
+	assert.Equal(t, float32(800), m1.M["main()"].WallTime)
+}
+
 func TestComputeNearestFamily(t *testing.T) {
 	expected := &NearestFamily{
 		Children: &PairCallMap{
@@ -230,6 +274,34 @@ func TestComputeNearestFamily(t *testing.T) {
 	f := m.ComputeNearestFamily("foo")
 
 	assert.EqualValues(t, expected, f)
+
+	families := m.ComputeNearestFamilies()
+	assert.Len(t, families, 3)
+	for name, family := range families {
+		assert.Equal(t, m.ComputeNearestFamily(name), family, name)
+	}
+}
+
+func TestPairCallMapCopy(t *testing.T) {
+	m := NewPairCallMap()
+	m.NewPairCall("main()").Add(&PairCall{Count: 1, WallTime: 100, Costs: map[string]float32{"Ir": 10}})
+	m.NewSource("main()").File = "index.php"
+	m.Sources["main()"].AddLineCosts(3, &PairCall{WallTime: 40})
+	m.Sources["main()"].AddCallSite("foo", "index.php", 3, 2)
+	m.Meta = map[string]string{"url": "/"}
+
+	c := m.Copy()
+	assert.Equal(t, m, c)
+
+	c.M["main()"].Costs["Ir"] = 20
+	c.Sources["main()"].Lines[3].WallTime = 50
+	c.Sources["main()"].CallSites[0].Count = 5
+	c.Meta["url"] = "/foo"
+
+	assert.Equal(t, float32(10), m.M["main()"].Costs["Ir"])
+	assert.Equal(t, float32(40), m.Sources["main()"].Lines[3].WallTime)
+	assert.Equal(t, 2, m.Sources["main()"].CallSites[0].Count)
+	assert.Equal(t, "/", m.Meta["url"])
 }
 
 func TestSubtractPairCallMaps(t *testing.T) {
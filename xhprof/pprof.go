@@ -0,0 +1,96 @@
+package xhprof
+
+import (
+	"io"
+	"math"
+
+	"github.com/google/pprof/profile"
+)
+
+// WritePprof writes the call tree of the map as a gzipped pprof profile.proto,
+// with one sample per stack holding the exclusive wall time, CPU time, memory,
+// number of allocations and allocated amount of the last frame.
+func WritePprof(w io.Writer, m *PairCallMap) error {
+	root, err := m.CallTree(exportThreshold)
+	if err != nil {
+		return err
+	}
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "wall", Unit: "microseconds"},
+			{Type: "cpu", Unit: "microseconds"},
+			{Type: "memory", Unit: "bytes"},
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+		},
+		DefaultSampleType: "wall",
+		PeriodType:        &profile.ValueType{Type: "wall", Unit: "microseconds"},
+		Period:            1,
+		DurationNanos:     int64(root.Inclusive.WallTime) * 1000,
+	}
+
+	locations := make(map[string]*profile.Location)
+	location := func(name string) *profile.Location {
+		loc, ok := locations[name]
+		if ok {
+			return loc
+		}
+
+		fn := &profile.Function{
+			ID:         uint64(len(p.Function) + 1),
+			Name:       name,
+			SystemName: name,
+		}
+		p.Function = append(p.Function, fn)
+
+		loc = &profile.Location{
+			ID:   uint64(len(p.Location) + 1),
+			Line: []profile.Line{{Function: fn}},
+		}
+		p.Location = append(p.Location, loc)
+		locations[name] = loc
+
+		return loc
+	}
+
+	root.Walk(func(n *CallNode) {
+		e := n.Exclusive()
+		values := []int64{
+			pprofValue(e.WallTime),
+			pprofValue(e.CpuTime),
+			pprofValue(e.Memory),
+			pprofValue(e.NumAlloc),
+			pprofValue(e.AllocAmount),
+		}
+
+		empty := true
+		for _, v := range values {
+			if v != 0 {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			return
+		}
+
+		stack := n.Stack()
+		locs := make([]*profile.Location, len(stack))
+		for i, name := range stack {
+			locs[len(stack)-1-i] = location(name)
+		}
+
+		p.Sample = append(p.Sample, &profile.Sample{Location: locs, Value: values})
+	})
+
+	if err = p.CheckValid(); err != nil {
+		return err
+	}
+
+	return p.Write(w)
+}
+
+func pprofValue(v float32) int64 {
+	return int64(math.Floor(float64(v) + 0.5))
+}
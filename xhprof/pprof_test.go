@@ -0,0 +1,69 @@
+package xhprof
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePprof(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime:    1000,
+				Count:       1,
+				CpuTime:     400,
+				Memory:      1500,
+				NumAlloc:    20,
+				AllocAmount: 2048,
+			},
+			"main()==>foo": &PairCall{
+				WallTime:    500,
+				Count:       2,
+				CpuTime:     200,
+				Memory:      700,
+				NumAlloc:    5,
+				AllocAmount: 1024,
+			},
+			"foo==>bar": &PairCall{
+				WallTime: 200,
+				Count:    10,
+				CpuTime:  100,
+				Memory:   300,
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	err := WritePprof(&b, m)
+	require.Nil(t, err)
+
+	p, err := profile.Parse(&b)
+	require.Nil(t, err)
+
+	types := make([]string, 0, len(p.SampleType))
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	assert.Equal(t, []string{"wall/microseconds", "cpu/microseconds", "memory/bytes", "alloc_objects/count", "alloc_space/bytes"}, types)
+	assert.Equal(t, "wall", p.DefaultSampleType)
+
+	require.Len(t, p.Sample, 3)
+	totals := make([]int64, len(p.SampleType))
+	for _, s := range p.Sample {
+		for i, v := range s.Value {
+			totals[i] += v
+		}
+	}
+	assert.Equal(t, []int64{1000, 400, 1500, 20, 2048}, totals)
+
+	leaf := p.Sample[2]
+	require.Len(t, leaf.Location, 3)
+	assert.Equal(t, "bar", leaf.Location[0].Line[0].Function.Name)
+	assert.Equal(t, "foo", leaf.Location[1].Line[0].Function.Name)
+	assert.Equal(t, "main()", leaf.Location[2].Line[0].Function.Name)
+	assert.Equal(t, []int64{200, 100, 300, 0, 0}, leaf.Value)
+}
@@ -160,9 +160,7 @@ func AvgProfiles(profiles []*Profile) *Profile {
 		for _, c := range p.Calls {
 			call, ok := callMap[c.Name]
 			if !ok {
-				call = new(Call)
-				*call = *c
-				callMap[call.Name] = call
+				callMap[c.Name] = c.Copy()
 				continue
 			}
 
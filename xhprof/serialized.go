@@ -0,0 +1,256 @@
+package xhprof
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+// UnserializePHP decodes a value encoded with PHP's serialize(). Arrays are
+// returned as map[string]interface{} with integer keys converted to strings,
+// integers as int64, floats as float64, booleans as bool and null as nil.
+// Objects are not supported.
+func UnserializePHP(data []byte) (interface{}, error) {
+	d := &phpDecoder{data: data}
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pos != len(bytes.TrimRight(data, " \t\r\n")) {
+		return nil, d.error("trailing data")
+	}
+
+	return v, nil
+}
+
+type phpDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *phpDecoder) error(msg string) error {
+	return fmt.Errorf("Invalid PHP serialized data at offset %d: %s", d.pos, msg)
+}
+
+func (d *phpDecoder) expect(c byte) error {
+	if d.pos >= len(d.data) || d.data[d.pos] != c {
+		return d.error(fmt.Sprintf("expected '%c'", c))
+	}
+
+	d.pos++
+	return nil
+}
+
+// readUntil returns the bytes up to the delimiter and skips the delimiter.
+func (d *phpDecoder) readUntil(delim byte) (string, error) {
+	i := bytes.IndexByte(d.data[d.pos:], delim)
+	if i < 0 {
+		return "", d.error(fmt.Sprintf("expected '%c'", delim))
+	}
+
+	s := string(d.data[d.pos : d.pos+i])
+	d.pos += i + 1
+
+	return s, nil
+}
+
+func (d *phpDecoder) decode() (interface{}, error) {
+	if d.pos+1 >= len(d.data) {
+		return nil, d.error("unexpected end of data")
+	}
+
+	t := d.data[d.pos]
+	if t == 'N' {
+		d.pos++
+		return nil, d.expect(';')
+	}
+
+	d.pos++
+	if err := d.expect(':'); err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case 'b':
+		v, err := d.readUntil(';')
+		if err != nil {
+			return nil, err
+		}
+
+		return v == "1", nil
+	case 'i':
+		v, err := d.readUntil(';')
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, d.error("invalid integer " + v)
+		}
+
+		return i, nil
+	case 'd':
+		v, err := d.readUntil(';')
+		if err != nil {
+			return nil, err
+		}
+
+		switch v {
+		case "INF":
+			return math.Inf(1), nil
+		case "-INF":
+			return math.Inf(-1), nil
+		case "NAN":
+			return math.NaN(), nil
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, d.error("invalid float " + v)
+		}
+
+		return f, nil
+	case 's':
+		return d.decodeString()
+	case 'a':
+		return d.decodeArray()
+	}
+
+	return nil, d.error(fmt.Sprintf("unsupported type '%c'", t))
+}
+
+func (d *phpDecoder) decodeString() (string, error) {
+	l, err := d.readUntil(':')
+	if err != nil {
+		return "", err
+	}
+
+	n, err := strconv.Atoi(l)
+	if err != nil || n < 0 {
+		return "", d.error("invalid string length " + l)
+	}
+
+	if err = d.expect('"'); err != nil {
+		return "", err
+	}
+
+	if d.pos+n > len(d.data) {
+		return "", d.error("unexpected end of data")
+	}
+
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n
+
+	if err = d.expect('"'); err != nil {
+		return "", err
+	}
+
+	return s, d.expect(';')
+}
+
+func (d *phpDecoder) decodeArray() (map[string]interface{}, error) {
+	l, err := d.readUntil(':')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(l)
+	if err != nil || n < 0 {
+		return nil, d.error("invalid array length " + l)
+	}
+
+	if err = d.expect('{'); err != nil {
+		return nil, err
+	}
+
+	a := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+
+		var key string
+		switch k := k.(type) {
+		case string:
+			key = k
+		case int64:
+			key = strconv.FormatInt(k, 10)
+		default:
+			return nil, d.error("array keys must be integers or strings")
+		}
+
+		if a[key], err = d.decode(); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, d.expect('}')
+}
+
+// ParseSerialized reads profiles serialized with PHP's serialize(), as
+// written by the original XHProf extension into /tmp/xhprof. Documents of
+// XHGui with the profile in a "profile" key are supported as well.
+func ParseSerialized(rd io.Reader) (*PairCallMap, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := UnserializePHP(data)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("PHP serialized profile must be an array")
+	}
+
+	if p, ok := profile["profile"].(map[string]interface{}); ok {
+		profile = p
+	}
+
+	m := NewPairCallMap()
+	for name, v := range profile {
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("PHP serialized profile has no array for %s", name)
+		}
+
+		pc := m.NewPairCall(name)
+		pc.Count = int(phpFloat(values["ct"]))
+		pc.WallTime = phpFloat(values["wt"])
+		pc.CpuTime = phpFloat(values["cpu"])
+		pc.Memory = phpFloat(values["mu"])
+		pc.PeakMemory = phpFloat(values["pmu"])
+		pc.NumAlloc = phpFloat(values["mem.na"])
+		pc.NumFree = phpFloat(values["mem.nf"])
+		pc.AllocAmount = phpFloat(values["mem.aa"])
+	}
+
+	return m, nil
+}
+
+func phpFloat(v interface{}) float32 {
+	switch v := v.(type) {
+	case int64:
+		return float32(v)
+	case float64:
+		return float32(v)
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return float32(f)
+	case bool:
+		if v {
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,52 @@
+package xhprof
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnserializePHP(t *testing.T) {
+	v, err := UnserializePHP([]byte(`a:6:{i:0;s:5:"a;b\"";s:1:"i";i:-42;s:1:"d";d:0.5;s:1:"b";b:1;s:1:"n";N;s:1:"a";a:1:{i:3;d:INF;}}`))
+	require.Nil(t, err)
+
+	a, ok := v.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, `a;b\"`, a["0"])
+	assert.Equal(t, int64(-42), a["i"])
+	assert.Equal(t, 0.5, a["d"])
+	assert.Equal(t, true, a["b"])
+	assert.Nil(t, a["n"])
+	assert.Contains(t, a, "n")
+	assert.True(t, math.IsInf(a["a"].(map[string]interface{})["3"].(float64), 1))
+
+	// String lengths are counted in bytes
+	v, err = UnserializePHP([]byte(`s:5:"äöx";`))
+	require.Nil(t, err)
+	assert.Equal(t, "äöx", v)
+
+	for _, data := range []string{"", "a:2:{i:0;i:1;}", `s:10:"abc";`, "i:abc;", `O:8:"stdClass":0:{}`, "i:1;i:2;", "a:1:{d:1.5;i:1;}"} {
+		_, err := UnserializePHP([]byte(data))
+		assert.NotNil(t, err, data)
+	}
+}
+
+func TestParseSerialized(t *testing.T) {
+	expected, err := NewFile("testdata/simple.xhprof", "xhprof").GetPairCallMap()
+	require.Nil(t, err)
+
+	f := NewFile("testdata/simple.serialized", "auto")
+	m, err := f.GetPairCallMap()
+	require.Nil(t, err)
+	assert.EqualValues(t, expected, m)
+
+	m, err = ParseSerialized(strings.NewReader(`a:1:{s:7:"profile";a:1:{s:6:"main()";a:2:{s:2:"ct";i:1;s:2:"wt";d:12.5;}}}`))
+	require.Nil(t, err)
+	assert.Equal(t, &PairCall{Count: 1, WallTime: 12.5}, m.M["main()"])
+
+	_, err = ParseSerialized(strings.NewReader(`a:1:{s:6:"main()";i:1;}`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,114 @@
+package xhprof
+
+import (
+	"sort"
+)
+
+// Source is the location of a function in the source code, as far as the
+// profile format provides it, together with the exclusive costs of each line
+// and the calls made from it.
+type Source struct {
+	File      string
+	Line      int
+	Lines     map[int]*PairCall
+	CallSites []*CallSite
+}
+
+// CallSite is a line of a function calling another function.
+type CallSite struct {
+	Function string
+	File     string
+	Line     int
+	Count    int
+}
+
+func newSource() *Source {
+	s := new(Source)
+	s.Lines = make(map[int]*PairCall)
+	s.CallSites = make([]*CallSite, 0, 1)
+
+	return s
+}
+
+// AddLineCosts adds exclusive costs spent in line of the function.
+func (s *Source) AddLineCosts(line int, costs *PairCall) {
+	pc, ok := s.Lines[line]
+	if !ok {
+		pc = new(PairCall)
+		s.Lines[line] = pc
+	}
+
+	pc.Add(costs)
+}
+
+// AddCallSite records count calls of function from line.
+func (s *Source) AddCallSite(function, file string, line, count int) {
+	for _, c := range s.CallSites {
+		if c.Function == function && c.File == file && c.Line == line {
+			c.Count += count
+			return
+		}
+	}
+
+	s.CallSites = append(s.CallSites, &CallSite{Function: function, File: file, Line: line, Count: count})
+}
+
+// GetCallSites returns the calls made from line.
+func (s *Source) GetCallSites(line int) []*CallSite {
+	r := make([]*CallSite, 0, 1)
+	for _, c := range s.CallSites {
+		if c.Line == line {
+			r = append(r, c)
+		}
+	}
+
+	return r
+}
+
+// SortedLines returns the lines with costs or calls in ascending order.
+func (s *Source) SortedLines() []int {
+	seen := make(map[int]bool, len(s.Lines))
+	lines := make([]int, 0, len(s.Lines))
+	for line := range s.Lines {
+		seen[line] = true
+		lines = append(lines, line)
+	}
+	for _, c := range s.CallSites {
+		if !seen[c.Line] {
+			seen[c.Line] = true
+			lines = append(lines, c.Line)
+		}
+	}
+	sort.Ints(lines)
+
+	return lines
+}
+
+func (s *Source) Add(o *Source) *Source {
+	if s.File == "" {
+		s.File = o.File
+		s.Line = o.Line
+	}
+
+	for line, costs := range o.Lines {
+		s.AddLineCosts(line, costs)
+	}
+
+	for _, c := range o.CallSites {
+		s.AddCallSite(c.Function, c.File, c.Line, c.Count)
+	}
+
+	return s
+}
+
+func (s *Source) Divide(d float32) *Source {
+	for _, costs := range s.Lines {
+		costs.Divide(d)
+	}
+
+	for _, c := range s.CallSites {
+		c.Count /= int(d)
+	}
+
+	return s
+}
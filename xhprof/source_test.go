@@ -0,0 +1,58 @@
+package xhprof
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceCallSites(t *testing.T) {
+	s := newSource()
+	s.AddCallSite("foo", "a.php", 3, 1)
+	s.AddCallSite("bar", "a.php", 3, 2)
+	s.AddCallSite("foo", "a.php", 3, 4)
+	s.AddCallSite("foo", "a.php", 7, 1)
+
+	assert.Equal(t, []*CallSite{
+		&CallSite{Function: "foo", File: "a.php", Line: 3, Count: 5},
+		&CallSite{Function: "bar", File: "a.php", Line: 3, Count: 2},
+	}, s.GetCallSites(3))
+	assert.Len(t, s.GetCallSites(5), 0)
+}
+
+func TestSourceSortedLines(t *testing.T) {
+	s := newSource()
+	s.AddLineCosts(10, &PairCall{WallTime: 5})
+	s.AddLineCosts(2, &PairCall{WallTime: 1})
+	s.AddLineCosts(10, &PairCall{WallTime: 3})
+	s.AddCallSite("foo", "a.php", 4, 1)
+	s.AddCallSite("foo", "a.php", 10, 1)
+
+	assert.Equal(t, []int{2, 4, 10}, s.SortedLines())
+	assert.Equal(t, float32(8), s.Lines[10].WallTime)
+}
+
+func TestAvgPairCallMapsSources(t *testing.T) {
+	m1 := NewPairCallMap()
+	m1.NewPairCall("main()").WallTime = 10
+	src := m1.NewSource("main()")
+	src.File = "index.php"
+	src.Line = 1
+	src.AddLineCosts(1, &PairCall{WallTime: 10})
+	src.AddCallSite("foo", "index.php", 2, 2)
+
+	m2 := NewPairCallMap()
+	m2.NewPairCall("main()").WallTime = 20
+	m2.NewSource("main()").AddLineCosts(1, &PairCall{WallTime: 20})
+
+	avg := AvgPairCallMaps([]*PairCallMap{m1, m2})
+
+	s := avg.Sources["main()"]
+	assert.Equal(t, "index.php", s.File)
+	assert.Equal(t, 1, s.Line)
+	assert.Equal(t, float32(15), s.Lines[1].WallTime)
+	assert.Equal(t, 1, s.CallSites[0].Count)
+
+	// The sources of the averaged maps are not modified
+	assert.Equal(t, float32(10), m1.Sources["main()"].Lines[1].WallTime)
+}
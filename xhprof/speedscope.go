@@ -0,0 +1,120 @@
+package xhprof
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+type speedscopeDimension struct {
+	Name string
+	Unit string
+}
+
+var speedscopeDimensions = map[string]speedscopeDimension{
+	"WallTime":    {Name: "Wall-Time", Unit: "microseconds"},
+	"CpuTime":     {Name: "CPU-Time", Unit: "microseconds"},
+	"Memory":      {Name: "Memory", Unit: "bytes"},
+	"NumAlloc":    {Name: "Number of Allocations", Unit: "none"},
+	"NumFree":     {Name: "Number of Frees", Unit: "none"},
+	"AllocAmount": {Name: "Amount of allocated Memory", Unit: "bytes"},
+}
+
+type speedscopeFile struct {
+	Schema             string               `json:"$schema"`
+	Shared             speedscopeShared     `json:"shared"`
+	Profiles           []*speedscopeProfile `json:"profiles"`
+	ActiveProfileIndex int                  `json:"activeProfileIndex"`
+	Exporter           string               `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type speedscopeProfile struct {
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Unit       string    `json:"unit"`
+	StartValue float32   `json:"startValue"`
+	EndValue   float32   `json:"endValue"`
+	Samples    [][]int   `json:"samples"`
+	Weights    []float32 `json:"weights"`
+}
+
+// WriteSpeedscope writes the call tree of the map in the speedscope file
+// format, with one sampled profile for each of the given PairCall fields that
+// has a positive value for main(). Events of callgrind files are given as
+// Costs.EVENT. The weight of each stack is the exclusive
+// value of the last frame.
+func WriteSpeedscope(w io.Writer, m *PairCallMap, fields []string) error {
+	root, err := m.CallTree(exportThreshold)
+	if err != nil {
+		return err
+	}
+
+	file := &speedscopeFile{
+		Schema:   speedscopeSchema,
+		Exporter: "tideways-toolkit",
+		Profiles: make([]*speedscopeProfile, 0, len(fields)),
+	}
+
+	frames := make(map[string]int)
+	frame := func(name string) int {
+		i, ok := frames[name]
+		if !ok {
+			i = len(file.Shared.Frames)
+			frames[name] = i
+			file.Shared.Frames = append(file.Shared.Frames, speedscopeFrame{Name: name})
+		}
+
+		return i
+	}
+
+	for _, field := range fields {
+		dim, ok := speedscopeDimensions[field]
+		if strings.HasPrefix(field, costsPrefix) {
+			dim, ok = speedscopeDimension{Name: strings.TrimPrefix(field, costsPrefix), Unit: "none"}, true
+		}
+		if !ok {
+			return errors.New("Dimension is not supported by speedscope: " + field)
+		}
+
+		if root.Inclusive.GetFloat32Field(field) <= 0 {
+			continue
+		}
+
+		p := &speedscopeProfile{Type: "sampled", Name: dim.Name, Unit: dim.Unit}
+		root.Walk(func(n *CallNode) {
+			weight := n.Exclusive().GetFloat32Field(field)
+			if weight <= 0 {
+				return
+			}
+
+			stack := n.Stack()
+			sample := make([]int, len(stack))
+			for i, name := range stack {
+				sample[i] = frame(name)
+			}
+
+			p.Samples = append(p.Samples, sample)
+			p.Weights = append(p.Weights, weight)
+			p.EndValue += weight
+		})
+
+		file.Profiles = append(file.Profiles, p)
+	}
+
+	if len(file.Profiles) == 0 {
+		return errors.New("Profile has no values for any of the requested dimensions")
+	}
+
+	return json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,72 @@
+package xhprof
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSpeedscope(t *testing.T) {
+	m := &PairCallMap{
+		M: map[string]*PairCall{
+			"main()": &PairCall{
+				WallTime: 1000,
+				Count:    1,
+				Memory:   1500,
+			},
+			"main()==>foo": &PairCall{
+				WallTime: 500,
+				Count:    2,
+				Memory:   1700,
+			},
+			"foo==>bar": &PairCall{
+				WallTime: 200,
+				Count:    10,
+				Memory:   300,
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	err := WriteSpeedscope(&b, m, []string{"WallTime", "CpuTime", "Memory"})
+	require.Nil(t, err)
+
+	var file speedscopeFile
+	err = json.Unmarshal(b.Bytes(), &file)
+	require.Nil(t, err)
+
+	assert.Equal(t, speedscopeSchema, file.Schema)
+	assert.Equal(t, []speedscopeFrame{{Name: "main()"}, {Name: "foo"}, {Name: "bar"}}, file.Shared.Frames)
+
+	require.Len(t, file.Profiles, 2)
+	wall := file.Profiles[0]
+	assert.Equal(t, "sampled", wall.Type)
+	assert.Equal(t, "microseconds", wall.Unit)
+	assert.Equal(t, float32(1000), wall.EndValue)
+	assert.Equal(t, [][]int{{0}, {0, 1}, {0, 1, 2}}, wall.Samples)
+	assert.Equal(t, []float32{500, 300, 200}, wall.Weights)
+
+	memory := file.Profiles[1]
+	assert.Equal(t, "bytes", memory.Unit)
+	assert.Equal(t, [][]int{{0, 1}, {0, 1, 2}}, memory.Samples)
+	assert.Equal(t, []float32{1400, 300}, memory.Weights)
+
+	err = WriteSpeedscope(&b, m, []string{"Count"})
+	assert.NotNil(t, err)
+
+	// Events of callgrind files are exported by their names.
+	m.M["main()"].Costs = map[string]float32{"Ir": 100}
+	m.M["main()==>foo"].Costs = map[string]float32{"Ir": 40}
+
+	b.Reset()
+	require.Nil(t, WriteSpeedscope(&b, m, []string{"Costs.Ir"}))
+	require.Nil(t, json.Unmarshal(b.Bytes(), &file))
+
+	require.Len(t, file.Profiles, 1)
+	assert.Equal(t, "Ir", file.Profiles[0].Name)
+	assert.Equal(t, "none", file.Profiles[0].Unit)
+	assert.Equal(t, []float32{60, 40}, file.Profiles[0].Weights)
+}
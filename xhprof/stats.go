@@ -0,0 +1,325 @@
+package xhprof
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary describes the values of a function in one dimension across a set of
+// profiles.
+type Summary struct {
+	N      int
+	Mean   float64
+	StdDev float64
+
+	// CILow and CIHigh bound the 95% confidence interval of the mean.
+	CILow  float64
+	CIHigh float64
+}
+
+// Summarize computes the mean, the sample standard deviation and the 95%
+// confidence interval of the mean, based on Student's t-distribution.
+func Summarize(values []float64) Summary {
+	s := Summary{N: len(values)}
+	if s.N == 0 {
+		return s
+	}
+
+	s.Mean, s.StdDev = meanStdDev(values)
+	s.CILow, s.CIHigh = s.Mean, s.Mean
+	if s.N > 1 {
+		d := studentTQuantile(0.05, float64(s.N-1)) * s.StdDev / math.Sqrt(float64(s.N))
+		s.CILow -= d
+		s.CIHigh += d
+	}
+
+	return s
+}
+
+func meanStdDev(values []float64) (mean, stdDev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+
+	return mean, math.Sqrt(sq / float64(len(values)-1))
+}
+
+// SignificanceTest returns the two-sided p-value of the hypothesis that the
+// samples a and b come from the same distribution.
+type SignificanceTest func(a, b []float64) float64
+
+// WelchTTest is Welch's t-test, which compares the means of the samples
+// without assuming that their variances are equal.
+func WelchTTest(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+
+	meanA, sdA := meanStdDev(a)
+	meanB, sdB := meanStdDev(b)
+	va := sdA * sdA / float64(len(a))
+	vb := sdB * sdB / float64(len(b))
+
+	if va+vb == 0 {
+		if meanA == meanB {
+			return 1
+		}
+
+		return 0
+	}
+
+	t := (meanA - meanB) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(len(a)-1) + vb*vb/float64(len(b)-1))
+
+	return studentTPValue(t, df)
+}
+
+// MannWhitneyU is the Mann-Whitney U test, which compares the ranks of the
+// values instead of their means and is therefore robust against outliers. The
+// p-value is computed with the normal approximation, corrected for ties.
+func MannWhitneyU(a, b []float64) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 1
+	}
+
+	type value struct {
+		v     float64
+		first bool
+	}
+
+	values := make([]value, 0, len(a)+len(b))
+	for _, v := range a {
+		values = append(values, value{v, true})
+	}
+	for _, v := range b {
+		values = append(values, value{v, false})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].v < values[j].v
+	})
+
+	// Tied values get the average of their ranks.
+	var rankSum, ties float64
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].v == values[i].v {
+			j++
+		}
+
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if values[k].first {
+				rankSum += rank
+			}
+		}
+
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+
+	// Continuity correction towards the mean of U.
+	d := math.Abs(u-n1*n2/2) - 0.5
+	if d < 0 {
+		d = 0
+	}
+
+	return math.Erfc(d / sigma / math.Sqrt2)
+}
+
+// studentTPValue returns the two-sided p-value of t in Student's
+// t-distribution with df degrees of freedom.
+func studentTPValue(t, df float64) float64 {
+	return regIncBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// studentTQuantile returns the t for which the two-sided p-value with df
+// degrees of freedom is p, e.g. 1.96 for p = 0.05 and many degrees of freedom.
+func studentTQuantile(p, df float64) float64 {
+	low, high := 0.0, 1.0
+	for studentTPValue(high, df) > p {
+		high *= 2
+	}
+
+	for i := 0; i < 100; i++ {
+		mid := (low + high) / 2
+		if studentTPValue(mid, df) > p {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return (low + high) / 2
+}
+
+// regIncBeta returns the regularized incomplete beta function I_x(a, b),
+// evaluated with its continued fraction.
+func regIncBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	} else if x >= 1 {
+		return 1
+	}
+
+	lgab, _ := math.Lgamma(a + b)
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges quickly only below this point, above
+	// it the symmetry I_x(a, b) = 1 - I_1-x(b, a) is used.
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaContinuedFraction(1-x, b, a)/b
+	}
+
+	return front * betaContinuedFraction(x, a, b) / a
+}
+
+// betaContinuedFraction evaluates the continued fraction of the incomplete
+// beta function with the modified Lentz's method.
+func betaContinuedFraction(x, a, b float64) float64 {
+	const tiny = 1e-300
+
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+
+	for m := 1.0; m <= 300; m++ {
+		for _, aa := range []float64{
+			m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m)),
+			-(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1)),
+		} {
+			d = 1 + aa*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + aa/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+
+		if math.Abs(d*c-1) < 1e-15 {
+			break
+		}
+	}
+
+	return h
+}
+
+// CallComparison compares the values of a function in one dimension between
+// two sets of profiles.
+type CallComparison struct {
+	Name      string
+	Baseline  Summary
+	Candidate Summary
+	PValue    float64
+}
+
+// Change returns the difference of the means, positive if the function got
+// more expensive in the candidate profiles.
+func (c *CallComparison) Change() float64 {
+	return c.Candidate.Mean - c.Baseline.Mean
+}
+
+// RelativeChange returns the change in percent of the baseline mean, or 0 if
+// the baseline mean is 0.
+func (c *CallComparison) RelativeChange() float64 {
+	if c.Baseline.Mean == 0 {
+		return 0
+	}
+
+	return 100 * c.Change() / c.Baseline.Mean
+}
+
+// Significant returns whether the change is statistically significant at
+// significance level alpha, e.g. 0.05.
+func (c *CallComparison) Significant(alpha float64) bool {
+	return c.PValue < alpha
+}
+
+// CompareProfiles compares each function of the baseline and candidate
+// profiles in field with test. Functions missing from a profile count as 0 in
+// it, functions that are 0 in all profiles are left out.
+func CompareProfiles(baseline, candidate []*Profile, field string, test SignificanceTest) []*CallComparison {
+	a := collectValues(baseline, field)
+	b := collectValues(candidate, field)
+
+	names := make(map[string]bool, len(a))
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+
+	res := make([]*CallComparison, 0, len(names))
+	for name := range names {
+		va, vb := a[name], b[name]
+		if va == nil {
+			va = make([]float64, len(baseline))
+		}
+		if vb == nil {
+			vb = make([]float64, len(candidate))
+		}
+
+		res = append(res, &CallComparison{
+			Name:      name,
+			Baseline:  Summarize(va),
+			Candidate: Summarize(vb),
+			PValue:    test(va, vb),
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res
+}
+
+// collectValues returns the values of field of each function, one per
+// profile, for the functions that are not 0 in all profiles.
+func collectValues(profiles []*Profile, field string) map[string][]float64 {
+	values := make(map[string][]float64)
+	for i, p := range profiles {
+		for _, c := range p.Calls {
+			v := c.GetFloat32Field(field)
+			if v == 0 {
+				continue
+			}
+
+			if _, ok := values[c.Name]; !ok {
+				values[c.Name] = make([]float64, len(profiles))
+			}
+
+			values[c.Name][i] = float64(v)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,89 @@
+package xhprof
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]float64{1, 2, 3, 4, 5})
+
+	assert.Equal(t, 5, s.N)
+	assert.InDelta(t, 3, s.Mean, 1e-9)
+	assert.InDelta(t, 1.58114, s.StdDev, 1e-5)
+	assert.InDelta(t, 1.03676, s.CILow, 1e-5)
+	assert.InDelta(t, 4.96324, s.CIHigh, 1e-5)
+
+	s = Summarize([]float64{7})
+	assert.Equal(t, Summary{N: 1, Mean: 7, CILow: 7, CIHigh: 7}, s)
+
+	assert.Equal(t, Summary{}, Summarize(nil))
+}
+
+func TestStudentTQuantile(t *testing.T) {
+	assert.InDelta(t, 12.7062, studentTQuantile(0.05, 1), 1e-4)
+	assert.InDelta(t, 2.77645, studentTQuantile(0.05, 4), 1e-5)
+	assert.InDelta(t, 1.95996, studentTQuantile(0.05, 1e6), 1e-4)
+}
+
+var (
+	statsSampleA = []float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4}
+	statsSampleB = []float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4}
+)
+
+func TestWelchTTest(t *testing.T) {
+	assert.InDelta(t, 0.02138, WelchTTest(statsSampleA, statsSampleB), 1e-4)
+	assert.InDelta(t, 0.02138, WelchTTest(statsSampleB, statsSampleA), 1e-4)
+	assert.InDelta(t, 1, WelchTTest(statsSampleA, statsSampleA), 1e-9)
+
+	assert.Equal(t, 1.0, WelchTTest([]float64{1, 1}, []float64{1, 1}))
+	assert.Equal(t, 0.0, WelchTTest([]float64{1, 1}, []float64{2, 2}))
+	assert.Equal(t, 1.0, WelchTTest([]float64{1}, []float64{2, 3}))
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	assert.InDelta(t, 0.02557, MannWhitneyU([]float64{1, 2, 2, 3}, []float64{3, 4, 4, 5, 6}), 1e-5)
+	assert.InDelta(t, 0.02557, MannWhitneyU([]float64{3, 4, 4, 5, 6}, []float64{1, 2, 2, 3}), 1e-5)
+
+	assert.Equal(t, 1.0, MannWhitneyU([]float64{1, 1}, []float64{1, 1, 1}))
+	assert.Equal(t, 1.0, MannWhitneyU(nil, []float64{1}))
+}
+
+func TestCompareProfiles(t *testing.T) {
+	newProfile := func(foo, bar float32) *Profile {
+		p := &Profile{Calls: []*Call{&Call{Name: "main()", WallTime: 100}}}
+		if foo != 0 {
+			p.Calls = append(p.Calls, &Call{Name: "foo", WallTime: foo})
+		}
+		if bar != 0 {
+			p.Calls = append(p.Calls, &Call{Name: "bar", WallTime: bar})
+		}
+
+		return p
+	}
+
+	baseline := []*Profile{newProfile(10, 0), newProfile(11, 0), newProfile(9, 0), newProfile(10, 0)}
+	candidate := []*Profile{newProfile(20, 5), newProfile(21, 0), newProfile(19, 5), newProfile(20, 0)}
+
+	res := CompareProfiles(baseline, candidate, "WallTime", WelchTTest)
+	require.Len(t, res, 3)
+
+	assert.Equal(t, "bar", res[0].Name)
+	assert.Equal(t, 0.0, res[0].Baseline.Mean)
+	assert.Equal(t, 2.5, res[0].Candidate.Mean)
+	assert.Equal(t, 0.0, res[0].RelativeChange())
+	assert.False(t, res[0].Significant(0.05))
+
+	assert.Equal(t, "foo", res[1].Name)
+	assert.Equal(t, 10.0, res[1].Baseline.Mean)
+	assert.Equal(t, 20.0, res[1].Candidate.Mean)
+	assert.Equal(t, 10.0, res[1].Change())
+	assert.Equal(t, 100.0, res[1].RelativeChange())
+	assert.True(t, res[1].Significant(0.05))
+
+	assert.Equal(t, "main()", res[2].Name)
+	assert.Equal(t, 0.0, res[2].Change())
+	assert.False(t, res[2].Significant(0.05))
+}
@@ -0,0 +1,237 @@
+package xhprof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Trace is the ordered call tree of a function trace, as opposed to the
+// aggregated parent==>child edges of a PairCallMap.
+type Trace struct {
+	Root *TraceCall
+}
+
+// TraceCall is a single call of a function in a trace. Start and End are in
+// microseconds since the start of the trace, memory is in bytes. File and
+// Line are the location the function was called from.
+type TraceCall struct {
+	Name        string
+	File        string
+	Line        int
+	Start       float64
+	End         float64
+	MemoryStart int64
+	MemoryEnd   int64
+	Parent      *TraceCall
+	Children    []*TraceCall
+}
+
+// Duration returns the wall time of the call in microseconds.
+func (c *TraceCall) Duration() float64 {
+	return c.End - c.Start
+}
+
+// Walk calls fn for the call and all calls made by it, in the order they
+// were made.
+func (c *TraceCall) Walk(fn func(*TraceCall)) {
+	fn(c)
+	for _, child := range c.Children {
+		child.Walk(fn)
+	}
+}
+
+// ParseXdebugTrace reads a function trace of Xdebug into a PairCallMap, see
+// ParseXdebugTraceTree.
+func ParseXdebugTrace(rd io.Reader) (*PairCallMap, error) {
+	t, err := ParseXdebugTraceTree(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.PairCallMap(), nil
+}
+
+// ParseXdebugTraceTree reads a function trace written by Xdebug with
+// trace_format=1 (xdebug.trace_format in Xdebug 2). Includes are named like
+// require::/path/to/file.php, and {main} becomes main(). If the trace does not
+// start at {main}, e.g. because it was started with xdebug_start_trace(),
+// all calls are attached to a main() spanning the whole trace. Calls that
+// have not returned when the trace ends are closed at its end.
+func ParseXdebugTraceTree(rd io.Reader) (*Trace, error) {
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	root := &TraceCall{Name: "main()", Start: -1}
+	stack := []*TraceCall{root}
+	levels := []int{0}
+	calls := make(map[string]*TraceCall)
+	var last float64
+	var lastMemory int64
+	hasMain := false
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+
+		// The last line of a trace only has the time and memory at its end.
+		if fields[0] == "" {
+			if t, err := strconv.ParseFloat(fields[3], 64); err == nil {
+				last = t * 1000000
+			}
+			if m, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
+				lastMemory = m
+			}
+			continue
+		}
+
+		if fields[2] != "0" && fields[2] != "1" {
+			continue
+		}
+
+		level, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid level in Xdebug trace: %s", scanner.Text())
+		}
+
+		t, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time in Xdebug trace: %s", scanner.Text())
+		}
+		t *= 1000000
+
+		memory, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid memory in Xdebug trace: %s", scanner.Text())
+		}
+
+		last, lastMemory = t, memory
+		if root.Start < 0 {
+			root.Start = t
+			root.MemoryStart = memory
+		}
+
+		if fields[2] == "1" {
+			c, ok := calls[fields[1]]
+			if !ok {
+				continue
+			}
+
+			c.End = t
+			c.MemoryEnd = memory
+			delete(calls, fields[1])
+
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i] == c {
+					stack, levels = stack[:i], levels[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("Function entry of Xdebug trace has less than 10 fields: %s", scanner.Text())
+		}
+
+		if level == 1 && fields[5] == "{main}" && len(root.Children) == 0 {
+			hasMain = true
+			root.Start = t
+			root.MemoryStart = memory
+			root.File = fields[8]
+			calls[fields[1]] = root
+			levels[0] = level
+			continue
+		}
+
+		c := &TraceCall{Name: fields[5], File: fields[8], Start: t, MemoryStart: memory}
+		if fields[7] != "" {
+			c.Name = fields[5] + "::" + fields[7]
+		}
+		c.Line, _ = strconv.Atoi(fields[9])
+
+		// Calls whose exit record is missing are left on the stack until a
+		// call at their level or above is entered.
+		for len(stack) > 1 && levels[len(levels)-1] >= level {
+			stack, levels = stack[:len(stack)-1], levels[:len(levels)-1]
+		}
+
+		parent := stack[len(stack)-1]
+		c.Parent = parent
+		parent.Children = append(parent.Children, c)
+		stack = append(stack, c)
+		levels = append(levels, level)
+		calls[fields[1]] = c
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if root.Start < 0 {
+		return nil, errors.New("Xdebug trace contains no function calls")
+	}
+
+	for _, c := range calls {
+		c.End = last
+		c.MemoryEnd = lastMemory
+	}
+	if !hasMain {
+		root.End = last
+		root.MemoryEnd = lastMemory
+	}
+
+	start := root.Start
+	root.Walk(func(c *TraceCall) {
+		c.Start -= start
+		c.End -= start
+	})
+
+	return &Trace{Root: root}, nil
+}
+
+// PairCallMap aggregates the calls of the trace into parent==>child edges.
+// Recursive calls are named like XHProf does (e.g. foo@1), and the calls
+// made from each line are kept as call sites of the caller.
+func (t *Trace) PairCallMap() *PairCallMap {
+	m := NewPairCallMap()
+	main := m.NewPairCall("main()")
+	main.Count = 1
+	main.WallTime = float32(t.Root.Duration())
+	main.Memory = float32(t.Root.MemoryEnd - t.Root.MemoryStart)
+
+	depths := make(map[string]int)
+	var add func(c *TraceCall, parent string)
+	add = func(c *TraceCall, parent string) {
+		name := c.Name
+		if depth := depths[c.Name]; depth > 0 {
+			name = fmt.Sprintf("%s@%d", c.Name, depth)
+		}
+
+		pc := m.NewPairCall(pairName(parent, name))
+		pc.Count++
+		pc.WallTime += float32(c.Duration())
+		pc.Memory += float32(c.MemoryEnd - c.MemoryStart)
+
+		if c.File != "" && c.Line > 0 {
+			m.NewSource(parent).AddCallSite(name, c.File, c.Line, 1)
+		}
+
+		depths[c.Name]++
+		for _, child := range c.Children {
+			add(child, name)
+		}
+		depths[c.Name]--
+	}
+
+	for _, c := range t.Root.Children {
+		add(c, "main()")
+	}
+
+	return m
+}
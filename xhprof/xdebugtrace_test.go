@@ -0,0 +1,109 @@
+package xhprof
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseXdebugTraceTree(t *testing.T) {
+	fh, err := os.Open("testdata/simple.xt")
+	require.Nil(t, err)
+	defer fh.Close()
+
+	trace, err := ParseXdebugTraceTree(fh)
+	require.Nil(t, err)
+
+	root := trace.Root
+	assert.Equal(t, "main()", root.Name)
+	assert.Equal(t, "/var/www/index.php", root.File)
+	assert.InDelta(t, 0, root.Start, 0.001)
+	assert.InDelta(t, 500, root.Duration(), 0.001)
+	assert.Equal(t, int64(1200), root.MemoryEnd-root.MemoryStart)
+
+	names := []string{}
+	for _, c := range root.Children {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"require::/var/www/lib.php", "fib", "strlen"}, names)
+
+	fib := root.Children[1]
+	assert.Equal(t, root, fib.Parent)
+	assert.Equal(t, "/var/www/index.php", fib.File)
+	assert.Equal(t, 5, fib.Line)
+	assert.InDelta(t, 200, fib.Start, 0.001)
+	assert.InDelta(t, 400, fib.End, 0.001)
+	require.Len(t, fib.Children, 1)
+
+	inner := fib.Children[0]
+	assert.Equal(t, "fib", inner.Name)
+	assert.Equal(t, "/var/www/lib.php", inner.File)
+	assert.Equal(t, 6, inner.Line)
+	require.Len(t, inner.Children, 1)
+	assert.Equal(t, "strlen", inner.Children[0].Name)
+	assert.InDelta(t, 10, inner.Children[0].Duration(), 0.001)
+}
+
+func TestParseXdebugTrace(t *testing.T) {
+	fh, err := os.Open("testdata/simple.xt")
+	require.Nil(t, err)
+	defer fh.Close()
+
+	m, err := ParseXdebugTrace(fh)
+	require.Nil(t, err)
+
+	expected := map[string]*PairCall{
+		"main()":                             &PairCall{Count: 1, WallTime: 500, Memory: 1200},
+		"main()==>require::/var/www/lib.php": &PairCall{Count: 1, WallTime: 100, Memory: 500},
+		"main()==>fib":                       &PairCall{Count: 1, WallTime: 200, Memory: 400},
+		"fib==>fib@1":                        &PairCall{Count: 1, WallTime: 90, Memory: 200},
+		"fib@1==>strlen":                     &PairCall{Count: 1, WallTime: 10},
+		"main()==>strlen":                    &PairCall{Count: 1, WallTime: 20},
+	}
+
+	require.Len(t, m.M, len(expected))
+	for name, e := range expected {
+		pc, ok := m.M[name]
+		require.True(t, ok, name)
+		assert.Equal(t, e.Count, pc.Count, name)
+		assert.InDelta(t, e.WallTime, pc.WallTime, 0.01, name)
+		assert.Equal(t, e.Memory, pc.Memory, name)
+	}
+
+	assert.Equal(t, []*CallSite{
+		&CallSite{Function: "require::/var/www/lib.php", File: "/var/www/index.php", Line: 3, Count: 1},
+	}, m.Sources["main()"].GetCallSites(3))
+	assert.Equal(t, []*CallSite{
+		&CallSite{Function: "strlen", File: "/var/www/lib.php", Line: 4, Count: 1},
+	}, m.Sources["fib@1"].GetCallSites(4))
+}
+
+func TestParseXdebugTraceIncomplete(t *testing.T) {
+	// Started with xdebug_start_trace() and cut off before foo returned
+	data := "TRACE START [2023-03-01 10:00:00.000000]\n" +
+		"3\t7\t0\t1.000000\t1000\tfoo\t1\t\t/app/a.php\t10\t0\n" +
+		"4\t8\t0\t1.000100\t1100\tbar\t1\t\t/app/a.php\t11\t0\n" +
+		"4\t8\t1\t1.000200\t1200\n" +
+		"3\t9\t0\t1.000300\t1200\tbaz\t1\t\t/app/a.php\t20\t0\n" +
+		"3\t9\t1\t1.000400\t1300\n" +
+		"\t\t\t1.000500\t1400\n"
+
+	trace, err := ParseXdebugTraceTree(strings.NewReader(data))
+	require.Nil(t, err)
+
+	root := trace.Root
+	assert.Equal(t, "main()", root.Name)
+	assert.InDelta(t, 500, root.Duration(), 0.01)
+	require.Len(t, root.Children, 2)
+	assert.Equal(t, "foo", root.Children[0].Name)
+	assert.Equal(t, "baz", root.Children[1].Name)
+	assert.InDelta(t, 500, root.Children[0].Duration(), 0.01)
+	require.Len(t, root.Children[0].Children, 1)
+	assert.Equal(t, "bar", root.Children[0].Children[0].Name)
+
+	_, err = ParseXdebugTraceTree(strings.NewReader("TRACE START [2023-03-01]\nTRACE END [2023-03-01]\n"))
+	assert.NotNil(t, err)
+}
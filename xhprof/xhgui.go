@@ -0,0 +1,256 @@
+package xhprof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xhguiDot replaces dots in the function names of XHGui profiles, because
+// MongoDB does not allow them in keys.
+const xhguiDot = "＿"
+
+type xhguiRun struct {
+	Profile map[string]map[string]interface{} `json:"profile"`
+	Meta    map[string]interface{}            `json:"meta"`
+}
+
+// ParseXhgui reads runs exported from XHGui and averages them, see
+// ParseXhguiRuns.
+func ParseXhgui(rd io.Reader) (*PairCallMap, error) {
+	runs, err := ParseXhguiRuns(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	return AvgPairCallMaps(runs), nil
+}
+
+// ParseXhguiRuns reads runs exported from XHGui's MongoDB, either a single
+// document, one document per line as written by mongoexport, or an array of
+// documents (mongoexport --jsonArray). The meta data of each run, like the
+// URL and the time of the request, is stored in the Meta of its map, with
+// nested keys joined by dots (e.g. SERVER.REQUEST_METHOD).
+func ParseXhguiRuns(rd io.Reader) ([]*PairCallMap, error) {
+	br := bufio.NewReader(rd)
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+
+	docs := make([]json.RawMessage, 0, 1)
+	if first, err := peekNonSpace(br); err != nil {
+		return nil, err
+	} else if first == '[' {
+		if err := dec.Decode(&docs); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			var doc json.RawMessage
+			err := dec.Decode(&doc)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			docs = append(docs, doc)
+		}
+	}
+
+	if len(docs) == 0 {
+		return nil, errors.New("XHGui export contains no runs")
+	}
+
+	runs := make([]*PairCallMap, 0, len(docs))
+	for i, doc := range docs {
+		m, err := parseXhguiRun(doc)
+		if err != nil {
+			return nil, fmt.Errorf("Run %d of XHGui export: %s", i+1, err)
+		}
+
+		runs = append(runs, m)
+	}
+
+	return runs, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, br.UnreadByte()
+		}
+	}
+}
+
+func parseXhguiRun(doc json.RawMessage) (*PairCallMap, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+
+	var run xhguiRun
+	if err := dec.Decode(&run); err != nil {
+		return nil, err
+	}
+
+	if run.Profile == nil {
+		return nil, errors.New("document has no profile")
+	}
+
+	m := NewPairCallMap()
+	for name, values := range run.Profile {
+		pc := m.NewPairCall(strings.Replace(name, xhguiDot, ".", -1))
+		pc.Count = int(xhguiFloat(values["ct"]))
+		pc.WallTime = xhguiFloat(values["wt"])
+		pc.CpuTime = xhguiFloat(values["cpu"])
+		pc.Memory = xhguiFloat(values["mu"])
+		pc.PeakMemory = xhguiFloat(values["pmu"])
+		pc.NumAlloc = xhguiFloat(values["mem.na"])
+		pc.NumFree = xhguiFloat(values["mem.nf"])
+		pc.AllocAmount = xhguiFloat(values["mem.aa"])
+	}
+
+	if len(run.Meta) > 0 {
+		m.Meta = make(map[string]string)
+		flattenXhguiMeta(m.Meta, "", run.Meta)
+	}
+
+	return m, nil
+}
+
+// xhguiValue converts the MongoDB extended JSON types of an export, like
+// {"$numberLong": "123"} or {"$date": ...}, into plain values.
+func xhguiValue(v interface{}) interface{} {
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+
+	for _, k := range []string{"$numberLong", "$numberInt", "$numberDouble", "$numberDecimal"} {
+		if n, ok := o[k]; ok {
+			if s, ok := n.(string); ok {
+				return json.Number(s)
+			}
+
+			return n
+		}
+	}
+
+	if oid, ok := o["$oid"]; ok {
+		return oid
+	}
+
+	if date, ok := o["$date"]; ok {
+		if s, ok := date.(string); ok {
+			return s
+		}
+
+		ms := xhguiFloat64(date)
+		return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+	}
+
+	// Legacy MongoDate of the PHP driver
+	if len(o) == 2 && o["sec"] != nil && o["usec"] != nil {
+		sec := xhguiFloat64(o["sec"])
+		return time.Unix(int64(sec), 0).UTC().Format(time.RFC3339)
+	}
+
+	return v
+}
+
+func xhguiFloat(v interface{}) float32 {
+	return float32(xhguiFloat64(v))
+}
+
+func xhguiFloat64(v interface{}) float64 {
+	switch v := xhguiValue(v).(type) {
+	case json.Number:
+		f, _ := strconv.ParseFloat(string(v), 64)
+		return f
+	case float64:
+		return v
+	}
+
+	return 0
+}
+
+func flattenXhguiMeta(meta map[string]string, prefix string, values map[string]interface{}) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := xhguiValue(values[k])
+		if o, ok := v.(map[string]interface{}); ok {
+			flattenXhguiMeta(meta, prefix+k+".", o)
+			continue
+		}
+
+		if v == nil {
+			continue
+		}
+
+		switch v := v.(type) {
+		case []interface{}:
+			data, _ := json.Marshal(v)
+			meta[prefix+k] = string(data)
+		default:
+			meta[prefix+k] = fmt.Sprint(v)
+		}
+	}
+}
+
+type xhguiDocument struct {
+	Meta    map[string]interface{} `json:"meta"`
+	Profile map[string]*PairCall   `json:"profile"`
+}
+
+// WriteXhgui writes the map as a run of XHGui that can be imported with
+// mongoimport. The keys of its Meta are nested again at their dots, and dots
+// in function names are replaced like XHGui does.
+func WriteXhgui(w io.Writer, m *PairCallMap) error {
+	doc := &xhguiDocument{
+		Meta:    make(map[string]interface{}),
+		Profile: make(map[string]*PairCall, len(m.M)),
+	}
+
+	keys := make([]string, 0, len(m.Meta))
+	for k := range m.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		path := strings.Split(k, ".")
+		o := doc.Meta
+		for _, p := range path[:len(path)-1] {
+			child, ok := o[p].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				o[p] = child
+			}
+
+			o = child
+		}
+
+		o[path[len(path)-1]] = m.Meta[k]
+	}
+
+	for name, pc := range m.M {
+		doc.Profile[strings.Replace(name, ".", xhguiDot, -1)] = pc
+	}
+
+	return json.NewEncoder(w).Encode(doc)
+}
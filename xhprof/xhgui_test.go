@@ -0,0 +1,79 @@
+package xhprof
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseXhguiRuns(t *testing.T) {
+	f := NewFile("testdata/xhgui.json", "auto")
+	runs, err := f.GetPairCallMaps()
+	require.Nil(t, err)
+	require.Len(t, runs, 2)
+
+	assert.Equal(t, &PairCall{Count: 1, WallTime: 1000, CpuTime: 400, Memory: 1500, PeakMemory: 2000}, runs[0].M["main()"])
+	assert.Equal(t, &PairCall{Count: 10, WallTime: 200, CpuTime: 100, Memory: 300, PeakMemory: 300}, runs[0].M["foo==>PDO.query"])
+
+	assert.Equal(t, "/index.php?p=1", runs[0].Meta["url"])
+	assert.Equal(t, "GET", runs[0].Meta["SERVER.REQUEST_METHOD"])
+	assert.Equal(t, "1528708207", runs[0].Meta["SERVER.REQUEST_TIME"])
+	assert.Equal(t, "1", runs[0].Meta["get.p"])
+	assert.Equal(t, "2018-06-11T09:10:07Z", runs[0].Meta["request_ts"])
+	assert.Equal(t, "2018-06-11T09:10:07Z", runs[0].Meta["request_ts_micro"])
+	assert.Equal(t, "[]", runs[0].Meta["env"])
+	assert.Equal(t, "2018-06-11T09:11:40Z", runs[1].Meta["request_ts"])
+
+	m, err := f.GetPairCallMap()
+	require.Nil(t, err)
+	assert.Equal(t, float32(2000), m.M["main()"].WallTime)
+	assert.Equal(t, 3, m.M["main()==>foo"].Count)
+}
+
+func TestParseXhguiArray(t *testing.T) {
+	data := `[
+		{"meta": {"url": "/a"}, "profile": {"main()": {"ct": 1, "wt": 10}}},
+		{"meta": {"url": "/b"}, "profile": {"main()": {"ct": 1, "wt": 30}}}
+	]`
+
+	runs, err := ParseXhguiRuns(strings.NewReader(data))
+	require.Nil(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "/b", runs[1].Meta["url"])
+
+	_, err = ParseXhguiRuns(strings.NewReader(`{"meta": {"url": "/a"}}`))
+	assert.NotNil(t, err)
+
+	_, err = ParseXhguiRuns(strings.NewReader("[]"))
+	assert.NotNil(t, err)
+}
+
+func TestWriteXhgui(t *testing.T) {
+	m := NewPairCallMap()
+	m.M["main()"] = &PairCall{Count: 1, WallTime: 100}
+	m.M["main()==>PDO.query"] = &PairCall{Count: 2, WallTime: 40}
+	m.Meta = map[string]string{
+		"url":                   "/checkout?step=2",
+		"SERVER.REQUEST_METHOD": "POST",
+		"SERVER.HTTP_HOST":      "shop.example.com",
+	}
+
+	var b bytes.Buffer
+	err := WriteXhgui(&b, m)
+	require.Nil(t, err)
+	assert.Contains(t, b.String(), `"SERVER":{"HTTP_HOST":"shop.example.com","REQUEST_METHOD":"POST"}`)
+	assert.Contains(t, b.String(), "PDO＿query")
+
+	format, err := DetectFormat(bufio.NewReader(bytes.NewReader(b.Bytes())))
+	require.Nil(t, err)
+	assert.Equal(t, "xhgui", format)
+
+	res, err := ParseXhgui(&b)
+	require.Nil(t, err)
+	assert.Equal(t, m.M, res.M)
+	assert.Equal(t, m.Meta, res.Meta)
+}